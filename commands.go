@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+const usage = `Usage: escarbot [command]
+
+Without a command the bot is started.
+
+Commands:
+  config check    print the effective configuration and report invalid options`
+
+// runCommand runs a command-line subcommand and returns the exit code.
+func runCommand(args []string, cfg telegram.Config, cfgErr error) int {
+	if len(args) == 2 && args[0] == "config" && args[1] == "check" {
+		return configCheck(cfg, cfgErr)
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// configCheck prints cfg with secrets redacted, followed by every
+// configuration error. It returns 1 if there were errors.
+func configCheck(cfg telegram.Config, cfgErr error) int {
+	fmt.Println(cfg.Redacted())
+	if cfgErr != nil {
+		fmt.Fprintf(os.Stderr, "\nConfiguration errors:\n%v\n", cfgErr)
+		return 1
+	}
+	fmt.Println("\nConfiguration OK.")
+	return 0
+}
@@ -3,50 +3,42 @@ package main
 import (
 	"log"
 	"os"
+	"time"
+	_ "time/tzdata" // the release image has no zoneinfo for TIMEZONE
 
 	"github.com/birabittoh/escarbot/telegram"
 	"github.com/birabittoh/escarbot/webui"
 	"github.com/joho/godotenv"
 )
 
+// envFile is the env file loaded at startup and watched for changes.
+const envFile = ".env"
+
 func main() {
-	err := godotenv.Load()
+	// Config reloads fall back to the environment the bot was started with,
+	// not to the values loaded from envFile.
+	environ := os.Environ()
+	err := godotenv.Load(envFile)
 	if err != nil {
 		log.Println("No .env file provided.")
 	}
 
-	botToken := os.Getenv("BOT_TOKEN")
-	if botToken == "" {
-		log.Fatal("Please set up your BOT_TOKEN in .env!")
-	}
-
-	channelId := os.Getenv("CHANNEL_ID")
-	if channelId == "" {
-		log.Fatal("Please set up your CHANNEL_ID in .env!")
-	}
+	cfg, cfgErr := telegram.LoadConfig()
 
-	groupId := os.Getenv("GROUP_ID")
-	if groupId == "" {
-		log.Fatal("Please set up your GROUP_ID in .env!")
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], cfg, cfgErr))
 	}
 
-	adminId := os.Getenv("ADMIN_ID")
-	if adminId == "" {
-		log.Fatal("Please set up your ADMIN_ID in .env!")
+	if cfgErr != nil {
+		log.Fatalf("Invalid configuration, please check your .env:\n%v", cfgErr)
 	}
 
-	logChannelId := os.Getenv("LOG_CHANNEL_ID")
-	if logChannelId == "" {
-		log.Fatal("Please set up your LOG_CHANNEL_ID in .env!")
+	bot := telegram.NewBot(cfg)
+	ui := webui.NewWebUI(cfg.Port, bot, cfg.DashboardAdmins, cfg.SessionSecret)
+	if cfg.PollInterval > 0 {
+		go telegram.WatchConfig(bot, envFile, environ, cfg.PollInterval, cfg)
 	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Println("PORT not set in .env! Defaulting to 3000.")
-		port = "3000"
-	}
-
-	bot := telegram.NewBot(botToken, channelId, groupId, adminId, logChannelId)
-	ui := webui.NewWebUI(port, bot)
+	go telegram.WatchPins(bot, time.Minute)
+	go telegram.WatchDeliveries(bot, 30*time.Second)
 	ui.Poll()
 }
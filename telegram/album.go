@@ -0,0 +1,140 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// albumWindow is how long the posts of a media group are buffered, after the
+// last one arrived, before being routed together.
+const albumWindow = 2 * time.Second
+
+// mediaGroupBuffer collects the messages of media groups, which Telegram
+// delivers one by one, and calls flush with all the messages of a group, in
+// order, once none has arrived for window.
+type mediaGroupBuffer struct {
+	window time.Duration
+	flush  func([]*tgbotapi.Message)
+
+	mu      sync.Mutex
+	pending map[string]*pendingAlbum
+}
+
+type pendingAlbum struct {
+	messages []*tgbotapi.Message
+	timer    *time.Timer
+}
+
+func newMediaGroupBuffer(window time.Duration, flush func([]*tgbotapi.Message)) *mediaGroupBuffer {
+	return &mediaGroupBuffer{
+		window:  window,
+		flush:   flush,
+		pending: make(map[string]*pendingAlbum),
+	}
+}
+
+// add buffers message, which must be part of a media group.
+func (b *mediaGroupBuffer) add(message *tgbotapi.Message) {
+	key := fmt.Sprintf("%d:%s", message.Chat.ID, message.MediaGroupID)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	album, ok := b.pending[key]
+	if !ok {
+		album = &pendingAlbum{timer: time.AfterFunc(b.window, func() { b.release(key) })}
+		b.pending[key] = album
+	} else {
+		album.timer.Reset(b.window)
+	}
+	album.messages = append(album.messages, message)
+}
+
+// release flushes the media group stored under key.
+func (b *mediaGroupBuffer) release(key string) {
+	b.mu.Lock()
+	album, ok := b.pending[key]
+	delete(b.pending, key)
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	slices.SortFunc(album.messages, func(a, b *tgbotapi.Message) int { return a.MessageID - b.MessageID })
+	b.flush(album.messages)
+}
+
+// routeAlbum sends the posts of a media group through the routes of their
+// channel, as a single album. A route takes the whole album if any of its
+// posts matches.
+func routeAlbum(escarbot *EscarBot, messages []*tgbotapi.Message) {
+	source := messages[0].Chat.ID
+	copies := make(map[int][]PostCopy)
+	for _, route := range channelRoutes(escarbot, source) {
+		if !slices.ContainsFunc(messages, route.Matches) {
+			continue
+		}
+		msgIDs, err := sendAlbum(escarbot, route, messages)
+		if err != nil {
+			log.Printf("Error routing album %s from %d to %d: %v", messages[0].MediaGroupID, source, route.Target, err)
+			continue
+		}
+		for i, msgID := range msgIDs {
+			if i < len(messages) {
+				copies[messages[i].MessageID] = append(copies[messages[i].MessageID], PostCopy{Route: route, MessageID: msgID})
+			}
+		}
+		if len(msgIDs) > 0 && slices.ContainsFunc(messages, route.pinMatches) {
+			autoPin(escarbot, route, msgIDs[0])
+		}
+	}
+	for msgID, postCopies := range copies {
+		escarbot.Cache.SetPostCopies(source, msgID, postCopies)
+	}
+}
+
+// sendAlbum forwards or copies the posts of a media group as route says,
+// returning the IDs of the sent messages. In copy mode the template replaces
+// the caption of the album; albums cannot have buttons.
+func sendAlbum(escarbot *EscarBot, route Route, messages []*tgbotapi.Message) ([]int, error) {
+	source := tgbotapi.ChatConfig{ChatID: messages[0].Chat.ID}
+	target := tgbotapi.BaseChat{ChatConfig: tgbotapi.ChatConfig{ChatID: route.Target}, MessageThreadID: route.ThreadID}
+	ids := make([]int, len(messages))
+	for i, message := range messages {
+		ids[i] = message.MessageID
+	}
+
+	var config tgbotapi.Chattable = tgbotapi.ForwardMessagesConfig{BaseChat: target, FromChat: source, MessageIDs: ids}
+	if route.Copy {
+		config = tgbotapi.CopyMessagesConfig{BaseChat: target, FromChat: source, MessageIDs: ids}
+	}
+	resp, err := escarbot.Bot.Request(config)
+	if err != nil {
+		return nil, err
+	}
+	var sent []tgbotapi.MessageID
+	if err := json.Unmarshal(resp.Result, &sent); err != nil {
+		return nil, err
+	}
+	msgIDs := make([]int, len(sent))
+	for i, s := range sent {
+		msgIDs[i] = s.MessageID
+	}
+
+	if route.Copy && route.Template != "" && len(msgIDs) == len(messages) {
+		// The caption shown under an album is the one of its first item
+		// with a caption.
+		i := max(slices.IndexFunc(messages, func(m *tgbotapi.Message) bool { return m.Caption != "" }), 0)
+		edit := tgbotapi.NewEditMessageCaption(route.Target, msgIDs[i], renderPostTemplate(route.Template, messages[i]))
+		edit.ParseMode = tgbotapi.ModeHTML
+		if _, err := escarbot.Bot.Request(edit); err != nil {
+			log.Printf("Error applying the template to album %s in %d: %v", messages[0].MediaGroupID, route.Target, err)
+		}
+	}
+	return msgIDs, nil
+}
@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// albumPost returns a synthetic channel photo post of media group group.
+func albumPost(id int, group, caption string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID:    id,
+		Chat:         tgbotapi.Chat{ID: -100, Title: "Chan"},
+		MediaGroupID: group,
+		Photo:        []tgbotapi.PhotoSize{{FileID: "f"}},
+		Caption:      caption,
+	}
+}
+
+func TestMediaGroupBuffer(t *testing.T) {
+	flushed := make(chan []int, 2)
+	buffer := newMediaGroupBuffer(20*time.Millisecond, func(messages []*tgbotapi.Message) {
+		var ids []int
+		for _, m := range messages {
+			ids = append(ids, m.MessageID)
+		}
+		flushed <- ids
+	})
+
+	buffer.add(albumPost(3, "a", ""))
+	buffer.add(albumPost(1, "a", "caption"))
+	buffer.add(albumPost(5, "b", ""))
+	buffer.add(albumPost(2, "a", ""))
+
+	var got [][]int
+	for range 2 {
+		select {
+		case ids := <-flushed:
+			got = append(got, ids)
+		case <-time.After(time.Second):
+			t.Fatalf("flushed %v, want two albums", got)
+		}
+	}
+	slices.SortFunc(got, func(a, b []int) int { return a[0] - b[0] })
+	if !slices.Equal(got[0], []int{1, 2, 3}) || !slices.Equal(got[1], []int{5}) {
+		t.Errorf("flushed %v, want [[1 2 3] [5]]", got)
+	}
+}
+
+func TestRouteAlbum(t *testing.T) {
+	var sent []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, method+" "+form.Get("chat_id")+" "+form.Get("message_ids")+form.Get("message_id")+form.Get("caption"))
+		switch method {
+		case "forwardMessages":
+			return true, `[{"message_id":11},{"message_id":12}]`
+		case "copyMessages":
+			return true, `[{"message_id":21},{"message_id":22}]`
+		}
+		return true, `{"message_id":1,"date":0,"chat":{"id":1,"type":"group"}}`
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache(""), ChannelID: -100, GroupID: 100, ChannelForward: true}
+	SetRoute(escarbot, Route{Source: -100, Target: 200})
+	SetRoute(escarbot, Route{Source: -100, Target: 300, Copy: true, Template: "{TEXT} via {CHANNEL_TITLE}"})
+	SetRoute(escarbot, Route{Source: -100, Target: 400, MediaTypes: []string{"video"}})
+
+	var flushed [][]*tgbotapi.Message
+	escarbot.mediaGroups = newMediaGroupBuffer(time.Hour, func(messages []*tgbotapi.Message) {
+		flushed = append(flushed, messages)
+	})
+	channelPostHandler(escarbot, albumPost(2, "a", ""))
+	channelPostHandler(escarbot, albumPost(1, "a", "hello"))
+	if len(sent) != 0 {
+		t.Fatalf("album items were sent before the album was complete: %q", sent)
+	}
+	escarbot.mediaGroups.release("-100:a")
+	if len(flushed) != 1 || len(flushed[0]) != 2 {
+		t.Fatalf("flushed %v, want one album of two posts", flushed)
+	}
+
+	routeAlbum(escarbot, flushed[0])
+	want := []string{
+		"forwardMessages 200 [1,2]",
+		"copyMessages 300 [1,2]",
+		"editMessageCaption 300 21hello via Chan",
+	}
+	if !slices.Equal(sent, want) {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+	if copies, _ := escarbot.Cache.GetPostCopies(-100, 2); len(copies) != 2 || copies[0].MessageID != 12 || copies[1].MessageID != 22 {
+		t.Errorf("copies of post 2 = %+v", copies)
+	}
+}
@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+const (
+	maxAuditEvents     = 500
+	maxAuditValueChars = 300
+)
+
+// AuditEvent records a configuration change or a moderation action, like a
+// ban, made from the dashboard or by reloading the config file. Events without
+// an operator ID come from the config file and name it in OperatorName.
+type AuditEvent struct {
+	Time         time.Time `json:"time"`
+	OperatorID   int64     `json:"operator_id,string"`
+	OperatorName string    `json:"operator_name"`
+	Setting      string    `json:"setting"`
+	OldValue     string    `json:"old_value"`
+	NewValue     string    `json:"new_value"`
+}
+
+// RecordAudit stores event in the cache and, if AuditLog is enabled, posts it
+// to the log channel with a #CONFIG tag.
+func RecordAudit(escarbot *EscarBot, event AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	escarbot.Cache.AddAuditEvent(event, maxAuditEvents)
+	log.Printf("Audit event by %s: %s = %q (was %q)",
+		auditSource(event), event.Setting, truncateValue(event.NewValue), truncateValue(event.OldValue))
+
+	escarbot.StateMutex.RLock()
+	auditLog := escarbot.AuditLog
+	logChannelID := escarbot.LogChannelID
+	escarbot.StateMutex.RUnlock()
+
+	if !auditLog || logChannelID == 0 {
+		return
+	}
+
+	msgText := strings.Builder{}
+	msgText.WriteString("⚙️ #CONFIG\n")
+	msgText.WriteString(fmt.Sprintf("<b>Setting</b>: <code>%s</code>\n", html.EscapeString(event.Setting)))
+	msgText.WriteString(fmt.Sprintf("<b>Old</b>: <code>%s</code>\n", html.EscapeString(truncateValue(event.OldValue))))
+	msgText.WriteString(fmt.Sprintf("<b>New</b>: <code>%s</code>\n", html.EscapeString(truncateValue(event.NewValue))))
+	if event.OperatorID == 0 {
+		msgText.WriteString(fmt.Sprintf("<b>By</b>: %s", html.EscapeString(event.OperatorName)))
+	} else {
+		msgText.WriteString(fmt.Sprintf("<b>By</b>: <a href=\"tg://user?id=%d\">%s</a> [<code>%d</code>]\n", event.OperatorID, html.EscapeString(event.OperatorName), event.OperatorID))
+		msgText.WriteString("#id" + strconv.FormatInt(event.OperatorID, 10))
+	}
+
+	logMsg := tgbotapi.NewMessage(logChannelID, msgText.String())
+	logMsg.ParseMode = "HTML"
+	logMsg.LinkPreviewOptions.IsDisabled = true
+	if _, err := escarbot.Bot.Send(logMsg); err != nil {
+		log.Printf("Error sending config log message: %v", err)
+	}
+}
+
+// auditSource describes who made event: a dashboard operator or, for events
+// without an operator ID, the source named in OperatorName.
+func auditSource(event AuditEvent) string {
+	if event.OperatorID == 0 {
+		return event.OperatorName
+	}
+	return fmt.Sprintf("dashboard operator %s (%d)", event.OperatorName, event.OperatorID)
+}
+
+// truncateValue shortens long values such as the welcome text for logs.
+func truncateValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= maxAuditValueChars {
+		return value
+	}
+	return string(runes[:maxAuditValueChars]) + "…"
+}
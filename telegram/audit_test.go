@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCacheAuditEvents(t *testing.T) {
+	cache := NewCache("") // in-memory mode
+
+	for i := 0; i < 5; i++ {
+		cache.AddAuditEvent(AuditEvent{Setting: "AutoBan", NewValue: strconv.Itoa(i)}, 3)
+	}
+
+	events := cache.GetAuditEvents(10)
+	if len(events) != 3 {
+		t.Fatalf("GetAuditEvents() returned %d events, want 3", len(events))
+	}
+	if events[0].NewValue != "4" || events[2].NewValue != "2" {
+		t.Errorf("GetAuditEvents() not newest first: %+v", events)
+	}
+	if events := cache.GetAuditEvents(1); len(events) != 1 || events[0].NewValue != "4" {
+		t.Errorf("GetAuditEvents(1) = %+v", events)
+	}
+}
+
+func TestRecordAudit(t *testing.T) {
+	escarbot := &EscarBot{Cache: NewCache("")} // AuditLog off, nothing is posted
+
+	RecordAudit(escarbot, AuditEvent{OperatorID: 42, Setting: "WelcomeText", NewValue: strings.Repeat("a", 1000)})
+
+	events := escarbot.Cache.GetAuditEvents(10)
+	if len(events) != 1 {
+		t.Fatalf("RecordAudit() stored %d events, want 1", len(events))
+	}
+	if events[0].Time.IsZero() {
+		t.Errorf("RecordAudit() did not set the event time")
+	}
+	if len(events[0].NewValue) != 1000 {
+		t.Errorf("RecordAudit() truncated the stored value")
+	}
+}
+
+func TestAuditSource(t *testing.T) {
+	if got := auditSource(AuditEvent{OperatorID: 42, OperatorName: "@alice"}); got != "dashboard operator @alice (42)" {
+		t.Errorf("dashboard: auditSource() = %q", got)
+	}
+	if got := auditSource(AuditEvent{OperatorName: reloadOperator}); got != "config file" {
+		t.Errorf("reload: auditSource() = %q", got)
+	}
+}
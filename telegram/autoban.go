@@ -11,17 +11,28 @@ import (
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
 
-func replacePlaceholders(escarbot *EscarBot, text string, user tgbotapi.User) string {
-	escarbot.StateMutex.RLock()
-	groupID := escarbot.GroupID
-	escarbot.StateMutex.RUnlock()
-
+func replacePlaceholders(text string, groupID int64, user tgbotapi.User) string {
 	replaced := strings.ReplaceAll(text, "{GROUP_ID}", strconv.FormatInt(groupID, 10))
 	replaced = strings.ReplaceAll(replaced, "{USER_NAME}", html.EscapeString(user.FirstName))
 	replaced = strings.ReplaceAll(replaced, "{USER_ID}", strconv.FormatInt(user.ID, 10))
 	return replaced
 }
 
+// linkButtons builds one row of URL buttons per "Text|URL" line of links,
+// passing each URL through replace. Malformed lines are skipped.
+func linkButtons(links string, replace func(string) string) [][]tgbotapi.InlineKeyboardButton {
+	var buttons [][]tgbotapi.InlineKeyboardButton
+	for _, line := range strings.Split(links, "\n") {
+		parts := strings.SplitN(line, "|", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		button := tgbotapi.NewInlineKeyboardButtonURL(parts[0], replace(parts[1]))
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	return buttons
+}
+
 // handleNewChatMembers handles new members joining the group via service message
 func handleNewChatMembers(escarbot *EscarBot, message *tgbotapi.Message) {
 	if message.NewChatMembers == nil {
@@ -49,12 +60,12 @@ func handleChatMemberUpdate(escarbot *EscarBot, update *tgbotapi.ChatMemberUpdat
 	isLeaving := newStatus == "left" || newStatus == "kicked" || newStatus == "banned"
 	if isLeaving && update.NewChatMember.User != nil {
 		userID := update.NewChatMember.User.ID
-		if pending, ok := escarbot.Cache.GetCaptcha(userID); ok && pending.ChatID == update.Chat.ID {
+		if pending, ok := escarbot.Cache.GetCaptcha(update.Chat.ID, userID); ok {
 			if pending.ExpirationTimer != nil {
 				pending.ExpirationTimer.Stop()
 			}
 			deleteMessages(escarbot, update.Chat.ID, pending.CaptchaMsgID)
-			escarbot.Cache.DeleteCaptcha(userID)
+			escarbot.Cache.DeleteCaptcha(update.Chat.ID, userID)
 			log.Printf("User %d left the group %d, pending captcha deleted", userID, update.Chat.ID)
 		}
 	}
@@ -66,29 +77,26 @@ func processJoin(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 		return
 	}
 
-	escarbot.StateMutex.RLock()
-	targetGroupID := escarbot.GroupID
-	escarbot.StateMutex.RUnlock()
-
-	if chatID != targetGroupID {
+	group, managed := GetGroupSettings(escarbot, chatID)
+	if !managed {
 		return
 	}
 
 	// Cleanup stale in-memory entries (no-op when Valkey handles TTL).
 	escarbot.Cache.CleanupJoinEntries()
 
-	// De-duplication: if processed within the last 10 seconds, skip.
-	if lastProcessed, exists := escarbot.Cache.GetJoinEntry(user.ID); exists {
+	// De-duplication: if processed in this chat within the last 10 seconds, skip.
+	if lastProcessed, exists := escarbot.Cache.GetJoinEntry(chatID, user.ID); exists {
 		if time.Since(lastProcessed.Time) < 10*time.Second {
 			// Update join message ID in both join and captcha caches if needed.
 			if joinMsgID != 0 && lastProcessed.JoinMsgID == 0 {
-				updated, _ := escarbot.Cache.UpdateJoinEntryMsgID(user.ID, joinMsgID)
+				updated, _ := escarbot.Cache.UpdateJoinEntryMsgID(chatID, user.ID, joinMsgID)
 				if updated != nil && updated.IsBanned {
 					deleteMessages(escarbot, chatID, joinMsgID)
 					log.Printf("Deleted late join message %d for banned user %d", joinMsgID, user.ID)
 				}
 			}
-			escarbot.Cache.UpdateCaptchaJoinMsgID(user.ID, joinMsgID)
+			escarbot.Cache.UpdateCaptchaJoinMsgID(chatID, user.ID, joinMsgID)
 			return
 		}
 	}
@@ -98,28 +106,22 @@ func processJoin(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 		JoinMsgID: joinMsgID,
 		IsBanned:  false,
 	}
-	escarbot.Cache.SetJoinEntry(user.ID, entry)
+	escarbot.Cache.SetJoinEntry(chatID, user.ID, entry)
 
-	escarbot.StateMutex.RLock()
-	autoBan := escarbot.AutoBan
-	captcha := escarbot.Captcha
-	welcomeMessage := escarbot.WelcomeMessage
-	escarbot.StateMutex.RUnlock()
-
-	if autoBan && hasBannedContent(escarbot, user.ID) {
+	if group.AutoBan && hasBannedContent(escarbot, user.ID, group.BannedWords) {
 		log.Printf("User %d (%s) has banned content in personal channel, proceeding with ban", user.ID, user.UserName)
 		banAndCleanup(escarbot, chatID, user, joinMsgID)
-		escarbot.Cache.UpdateJoinEntryBanned(user.ID)
+		escarbot.Cache.UpdateJoinEntryBanned(chatID, user.ID)
 		return
 	}
 
-	if captcha {
+	if group.Captcha {
 		restrictUser(escarbot, chatID, user.ID)
 		SendCaptcha(escarbot, chatID, user, joinMsgID, 0)
 		return
 	}
 
-	if !welcomeMessage {
+	if !group.WelcomeMessage {
 		return
 	}
 
@@ -127,28 +129,16 @@ func processJoin(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 }
 
 func sendWelcomeMessage(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
-	escarbot.StateMutex.RLock()
-	welcomeLinks := escarbot.WelcomeLinks
-	welcomePhoto := escarbot.WelcomePhoto
-	welcomeText := escarbot.WelcomeText
-	escarbot.StateMutex.RUnlock()
+	group, _ := GetGroupSettings(escarbot, chatID)
+	welcomeLinks := group.WelcomeLinks
+	welcomePhoto := group.WelcomePhoto
+	welcomeText := group.WelcomeText
 
-	links := strings.Split(welcomeLinks, "\n")
-	var buttons [][]tgbotapi.InlineKeyboardButton
-	for _, line := range links {
-		parts := strings.SplitN(line, "|", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		text := parts[0]
-		url := replacePlaceholders(escarbot, parts[1], user)
-		button := tgbotapi.NewInlineKeyboardButtonURL(text, url)
-		buttons = append(buttons, tgbotapi.NewInlineKeyboardRow(button))
-	}
+	buttons := linkButtons(welcomeLinks, func(url string) string { return replacePlaceholders(url, chatID, user) })
 
 	var welcomeMsg tgbotapi.Chattable
 	if welcomePhoto == "" {
-		msg := tgbotapi.NewMessage(chatID, replacePlaceholders(escarbot, welcomeText, user))
+		msg := tgbotapi.NewMessage(chatID, replacePlaceholders(welcomeText, chatID, user))
 		msg.ParseMode = "HTML"
 		if len(buttons) > 0 {
 			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons...)
@@ -156,7 +146,7 @@ func sendWelcomeMessage(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
 		welcomeMsg = msg
 	} else if welcomeText != "" {
 		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(welcomePhoto))
-		photo.Caption = replacePlaceholders(escarbot, welcomeText, user)
+		photo.Caption = replacePlaceholders(welcomeText, chatID, user)
 		photo.ParseMode = "HTML"
 		if len(buttons) > 0 {
 			photo.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons...)
@@ -174,8 +164,8 @@ func sendWelcomeMessage(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
 	}
 }
 
-// hasBannedContent checks if user has a personal channel with banned words
-func hasBannedContent(escarbot *EscarBot, userID int64) bool {
+// hasBannedContent checks if user has a personal channel with one of bannedWords
+func hasBannedContent(escarbot *EscarBot, userID int64, bannedWords []string) bool {
 	chatConfig := tgbotapi.ChatInfoConfig{
 		ChatConfig: tgbotapi.ChatConfig{ChatID: userID},
 	}
@@ -189,11 +179,6 @@ func hasBannedContent(escarbot *EscarBot, userID int64) bool {
 	if chat.PersonalChat != nil {
 		channelName := strings.ToLower(chat.PersonalChat.Title)
 
-		escarbot.StateMutex.RLock()
-		bannedWords := make([]string, len(escarbot.BannedWords))
-		copy(bannedWords, escarbot.BannedWords)
-		escarbot.StateMutex.RUnlock()
-
 		for _, word := range bannedWords {
 			if strings.Contains(channelName, strings.ToLower(word)) {
 				log.Printf("Found banned word '%s' in personal channel: %s", word, chat.PersonalChat.Title)
@@ -205,8 +190,9 @@ func hasBannedContent(escarbot *EscarBot, userID int64) bool {
 	return false
 }
 
-// banUser bans a user from the group
-func banUser(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
+// BanUser bans a user from the group and, if that worked, posts it to the log
+// channel.
+func BanUser(escarbot *EscarBot, chatID int64, user tgbotapi.User) error {
 	banConfig := tgbotapi.BanChatMemberConfig{
 		ChatMemberConfig: tgbotapi.ChatMemberConfig{
 			ChatConfig: tgbotapi.ChatConfig{ChatID: chatID},
@@ -215,12 +201,11 @@ func banUser(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
 		RevokeMessages: true,
 	}
 
-	_, err := escarbot.Bot.Request(banConfig)
-	if err != nil {
+	if _, err := escarbot.Bot.Request(banConfig); err != nil {
 		log.Printf("Error banning user %d: %v", user.ID, err)
-	} else {
-		log.Printf("User %d banned successfully", user.ID)
+		return err
 	}
+	log.Printf("User %d banned successfully", user.ID)
 
 	escarbot.StateMutex.RLock()
 	logChannelID := escarbot.LogChannelID
@@ -234,10 +219,11 @@ func banUser(escarbot *EscarBot, chatID int64, user tgbotapi.User) {
 	logMsg := tgbotapi.NewMessage(logChannelID, msgText.String())
 	logMsg.ParseMode = "HTML"
 	logMsg.LinkPreviewOptions.IsDisabled = true
-	_, err = escarbot.Bot.Send(logMsg)
+	_, err := escarbot.Bot.Send(logMsg)
 	if err != nil {
 		log.Printf("Error sending ban log message: %v", err)
 	}
+	return nil
 }
 
 // deleteMessages deletes multiple messages from a chat
@@ -275,7 +261,7 @@ func updateIndividualReactionInCache(escarbot *EscarBot, update *tgbotapi.Messag
 
 // banAndCleanup bans a user and deletes relevant messages
 func banAndCleanup(escarbot *EscarBot, chatID int64, user tgbotapi.User, messageIDs ...int) {
-	banUser(escarbot, chatID, user)
+	BanUser(escarbot, chatID, user)
 	deleteMessages(escarbot, chatID, messageIDs...)
 }
 
@@ -310,9 +296,11 @@ func AddMessageToCache(escarbot *EscarBot, message *tgbotapi.Message) {
 
 	reactions := getAvailableReactions(escarbot, message.Chat.ID)
 
+	var fromID int64
 	fromUsername := ""
 	fromFirstName := "Channel"
 	if message.From != nil {
+		fromID = message.From.ID
 		fromUsername = message.From.UserName
 		fromFirstName = message.From.FirstName
 	} else if message.SenderChat != nil {
@@ -380,6 +368,7 @@ func AddMessageToCache(escarbot *EscarBot, message *tgbotapi.Message) {
 		ChatID:             message.Chat.ID,
 		ChatTitle:          chatInfo.Title,
 		ChatPhotoURL:       chatInfo.PhotoURL,
+		FromID:             fromID,
 		FromUsername:       fromUsername,
 		FromFirstName:      fromFirstName,
 		Text:               message.Text,
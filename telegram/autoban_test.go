@@ -1,6 +1,8 @@
 package telegram
 
 import (
+	"net/url"
+	"strings"
 	"testing"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
@@ -16,7 +18,7 @@ func TestHandleChatMemberUpdateLeaveWrongChat(t *testing.T) {
 	chatID := int64(100)
 	captchaMsgID := 456
 
-	bot.Cache.SetCaptcha(userID, &PendingCaptcha{
+	bot.Cache.SetCaptcha(chatID, userID, &PendingCaptcha{
 		UserID:       userID,
 		ChatID:       chatID,
 		CaptchaMsgID: captchaMsgID,
@@ -34,7 +36,7 @@ func TestHandleChatMemberUpdateLeaveWrongChat(t *testing.T) {
 
 	handleChatMemberUpdate(bot, update)
 
-	if !bot.Cache.HasCaptcha(userID) {
+	if !bot.Cache.HasCaptcha(chatID, userID) {
 		t.Errorf("handleChatMemberUpdate() should NOT have deleted captcha for wrong chatID")
 	}
 
@@ -42,7 +44,7 @@ func TestHandleChatMemberUpdateLeaveWrongChat(t *testing.T) {
 	update.Chat.ID = chatID
 	handleChatMemberUpdate(bot, update)
 
-	if bot.Cache.HasCaptcha(userID) {
+	if bot.Cache.HasCaptcha(chatID, userID) {
 		t.Errorf("handleChatMemberUpdate() SHOULD have deleted captcha for correct chatID")
 	}
 }
@@ -61,7 +63,7 @@ func TestProcessJoinIgnoresOtherChats(t *testing.T) {
 	// Since we can't easily check internal flow without mocking a lot,
 	// let's check if a JoinEntry was created.
 	// processJoin would create a JoinEntry early for the correct chat.
-	if _, exists := bot.Cache.GetJoinEntry(user.ID); exists {
+	if _, exists := bot.Cache.GetJoinEntry(200, user.ID); exists {
 		t.Errorf("processJoin() should NOT have created join entry for wrong chatID")
 	}
 
@@ -69,3 +71,29 @@ func TestProcessJoinIgnoresOtherChats(t *testing.T) {
 	// It should proceed and eventually reach SetJoinEntry before possibly crashing/failing on Bot calls.
 	// But let's just use the early return check.
 }
+
+func TestBanUserLogsOnlyBans(t *testing.T) {
+	var calls []string
+	banFails := false
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		calls = append(calls, method)
+		if method == "banChatMember" && banFails {
+			return false, `"Bad Request: not enough rights to restrict/unrestrict chat member"`
+		}
+		if method == "sendMessage" {
+			return true, `{"message_id":1,"date":0,"chat":{"id":-300,"type":"channel"}}`
+		}
+		return true, "true"
+	})
+	bot := &EscarBot{Bot: api, Cache: NewCache(""), LogChannelID: -300}
+	user := tgbotapi.User{ID: 5, FirstName: "Spammer"}
+
+	if err := BanUser(bot, -100, user); err != nil || strings.Join(calls, " ") != "banChatMember sendMessage" {
+		t.Errorf("ban: calls = %v, error = %v", calls, err)
+	}
+
+	calls, banFails = nil, true
+	if err := BanUser(bot, -100, user); err == nil || strings.Join(calls, " ") != "banChatMember" {
+		t.Errorf("failed ban: calls = %v, error = %v", calls, err)
+	}
+}
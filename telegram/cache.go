@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -20,7 +21,23 @@ const (
 	keyPrefixReactions = "escarbot:reactions:"
 	keyPrefixCaptcha   = "escarbot:captcha:"
 	keyPrefixJoin      = "escarbot:join:"
+	keyRoles           = "escarbot:roles"
+	keyAudit           = "escarbot:audit"
+	keySnapshots       = "escarbot:snapshots"
+	keyPrefixModmail   = "escarbot:modmail:"
+	keyModmailBlocked  = "escarbot:modmail_blocked"
+	keyTickets         = "escarbot:tickets"
+	keyTicketThreads   = "escarbot:ticket_threads"
+	keyPrefixPostCopy  = "escarbot:post_copies:"
+	keyPins            = "escarbot:pins"
+	keyDeliveries      = "escarbot:deliveries"
+	keyPrefixLinkFix   = "escarbot:link_fix:"
+	keyPrefixResolved  = "escarbot:resolved:"
 	joinTTL            = time.Minute
+	postCopiesTTL      = 30 * 24 * time.Hour
+	modmailTTL         = 30 * 24 * time.Hour
+	linkFixTTL         = 2 * 24 * time.Hour
+	resolvedTTL        = 7 * 24 * time.Hour
 )
 
 // pendingCaptchaRecord is the serialisable part of PendingCaptcha (no timer).
@@ -45,12 +62,23 @@ type Cache struct {
 	messages  map[int64][]CachedMessage
 	chats     map[int64]ChatInfo
 	reactions map[int64][]string
-	captchas  map[int64]*pendingCaptchaRecord
-	joins     map[int64]*JoinProcessedEntry
+	captchas  map[string]*pendingCaptchaRecord
+	joins     map[string]*JoinProcessedEntry
+	roles     map[int64]Role
+	audit     []AuditEvent
+	snapshots []SettingsSnapshot
+	modmail   expiringMap[int64]
+	blocked   map[int64]bool
+	tickets   map[int64]Ticket
+	copies    expiringMap[[]PostCopy]
+	pins      map[int64][]AutoPin
+	queue     map[string]QueuedPost
+	linkFixes expiringMap[LinkFix]
+	resolved  expiringMap[string]
 
 	// Timers are always kept in-memory regardless of backend.
 	timerMu sync.Mutex
-	timers  map[int64]*time.Timer
+	timers  map[string]*time.Timer
 }
 
 // NewCache creates a Cache backed by Valkey at addr, or in-memory if addr is
@@ -61,14 +89,19 @@ func NewCache(addr string) *Cache {
 		messages:  make(map[int64][]CachedMessage),
 		chats:     make(map[int64]ChatInfo),
 		reactions: make(map[int64][]string),
-		captchas:  make(map[int64]*pendingCaptchaRecord),
-		joins:     make(map[int64]*JoinProcessedEntry),
-		timers:    make(map[int64]*time.Timer),
+		captchas:  make(map[string]*pendingCaptchaRecord),
+		joins:     make(map[string]*JoinProcessedEntry),
+		roles:     make(map[int64]Role),
+		blocked:   make(map[int64]bool),
+		tickets:   make(map[int64]Ticket),
+		pins:      make(map[int64][]AutoPin),
+		queue:     make(map[string]QueuedPost),
+		timers:    make(map[string]*time.Timer),
 	}
 	if addr != "" {
 		c.client = redis.NewClient(&redis.Options{Addr: addr})
 		if err := c.client.Ping(c.ctx).Err(); err != nil {
-			log.Printf("Warning: failed to connect to Valkey at %s: %v (falling back to in-memory)", addr, err)
+			log.Printf("Warning: failed to connect to Valkey at %s: %v (falling back to in-memory)", redactAddr(addr), err)
 			c.client = nil
 		} else {
 			log.Printf("Connected to Valkey at %s", addr)
@@ -77,6 +110,58 @@ func NewCache(addr string) *Cache {
 	return c
 }
 
+// ── Expiring entries ──────────────────────────────────────────────────────────
+
+// minSweepSize is the size at which an expiringMap is first swept.
+const minSweepSize = 64
+
+// expiringEntry is an in-memory value that expires like a Valkey key with a
+// TTL.
+type expiringEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// expiringMap is the in-memory counterpart of Valkey keys with a TTL. Expired
+// entries are swept whenever the map doubles in size, so that it only grows
+// with the entries that are still valid. It is guarded by Cache.mu.
+type expiringMap[T any] struct {
+	entries map[string]expiringEntry[T]
+	sweepAt int // size at which expired entries are next swept
+}
+
+// set stores value under key for ttl.
+func (m *expiringMap[T]) set(key string, value T, ttl time.Duration) {
+	now := time.Now()
+	if m.entries == nil {
+		m.entries = make(map[string]expiringEntry[T])
+	}
+	if len(m.entries) >= m.sweepAt {
+		for k, entry := range m.entries {
+			if now.After(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.sweepAt = max(2*len(m.entries), minSweepSize)
+	}
+	m.entries[key] = expiringEntry[T]{value: value, expires: now.Add(ttl)}
+}
+
+// get returns the value under key, unless it has expired.
+func (m *expiringMap[T]) get(key string) (T, bool) {
+	entry, ok := m.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var zero T
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// delete removes key.
+func (m *expiringMap[T]) delete(key string) {
+	delete(m.entries, key)
+}
+
 // ── Message cache ─────────────────────────────────────────────────────────────
 
 // GetMessages returns all cached messages for the given chat.
@@ -206,6 +291,30 @@ func (c *Cache) UpdateMessage(chatID int64, updated *tgbotapi.Message) (CachedMe
 	return CachedMessage{}, false
 }
 
+// DeleteMessage removes a message from the cache. Returns true when found.
+func (c *Cache) DeleteMessage(chatID int64, msgID int) bool {
+	if c.client != nil {
+		msgs := c.GetMessages(chatID)
+		for i, m := range msgs {
+			if m.MessageID == msgID {
+				c.setMessages(chatID, append(msgs[:i], msgs[i+1:]...))
+				return true
+			}
+		}
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := c.messages[chatID]
+	for i, m := range msgs {
+		if m.MessageID == msgID {
+			c.messages[chatID] = append(msgs[:i:i], msgs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // UpdateReactions updates the aggregate reaction counts for a cached message.
 func (c *Cache) UpdateReactions(chatID int64, msgID int, reactions []tgbotapi.ReactionCount) (CachedMessage, bool) {
 	if c.client != nil {
@@ -462,26 +571,33 @@ func (c *Cache) SetReactions(chatID int64, reactions []string) {
 
 // ── Captcha cache ─────────────────────────────────────────────────────────────
 
-// GetCaptcha returns the pending captcha state for a user.
-func (c *Cache) GetCaptcha(userID int64) (*PendingCaptcha, bool) {
+// memberField identifies user userID in chat chatID in cache keys, as users
+// can join several managed groups at once.
+func memberField(chatID, userID int64) string {
+	return fmt.Sprintf("%d:%d", chatID, userID)
+}
+
+// GetCaptcha returns the pending captcha state for a user in chatID.
+func (c *Cache) GetCaptcha(chatID, userID int64) (*PendingCaptcha, bool) {
 	var record pendingCaptchaRecord
+	field := memberField(chatID, userID)
 
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixCaptcha, userID)
+		key := keyPrefixCaptcha + field
 		val, err := c.client.Get(c.ctx, key).Result()
 		if err == redis.Nil {
 			return nil, false
 		} else if err != nil {
-			log.Printf("Cache: get captcha user %d: %v", userID, err)
+			log.Printf("Cache: get captcha %s: %v", field, err)
 			return nil, false
 		}
 		if err := json.Unmarshal([]byte(val), &record); err != nil {
-			log.Printf("Cache: unmarshal captcha user %d: %v", userID, err)
+			log.Printf("Cache: unmarshal captcha %s: %v", field, err)
 			return nil, false
 		}
 	} else {
 		c.mu.RLock()
-		r, ok := c.captchas[userID]
+		r, ok := c.captchas[field]
 		c.mu.RUnlock()
 		if !ok {
 			return nil, false
@@ -490,7 +606,7 @@ func (c *Cache) GetCaptcha(userID int64) (*PendingCaptcha, bool) {
 	}
 
 	c.timerMu.Lock()
-	timer := c.timers[userID]
+	timer := c.timers[field]
 	c.timerMu.Unlock()
 
 	return &PendingCaptcha{
@@ -505,9 +621,11 @@ func (c *Cache) GetCaptcha(userID int64) (*PendingCaptcha, bool) {
 	}, true
 }
 
-// SetCaptcha stores a pending captcha. The ExpirationTimer is kept in-memory;
-// when using Valkey the record is stored with the given TTL.
-func (c *Cache) SetCaptcha(userID int64, captcha *PendingCaptcha, ttl time.Duration) {
+// SetCaptcha stores the pending captcha of a user in chatID. The
+// ExpirationTimer is kept in-memory; when using Valkey the record is stored
+// with the given TTL.
+func (c *Cache) SetCaptcha(chatID, userID int64, captcha *PendingCaptcha, ttl time.Duration) {
+	field := memberField(chatID, userID)
 	record := pendingCaptchaRecord{
 		UserID:        captcha.UserID,
 		UserFirstName: captcha.UserFirstName,
@@ -518,102 +636,104 @@ func (c *Cache) SetCaptcha(userID int64, captcha *PendingCaptcha, ttl time.Durat
 		Attempts:      captcha.Attempts,
 	}
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixCaptcha, userID)
+		key := keyPrefixCaptcha + field
 		data, err := json.Marshal(record)
 		if err != nil {
-			log.Printf("Cache: marshal captcha user %d: %v", userID, err)
+			log.Printf("Cache: marshal captcha %s: %v", field, err)
 			return
 		}
 		if err := c.client.Set(c.ctx, key, data, ttl).Err(); err != nil {
-			log.Printf("Cache: set captcha user %d: %v", userID, err)
+			log.Printf("Cache: set captcha %s: %v", field, err)
 		}
 	} else {
 		c.mu.Lock()
-		c.captchas[userID] = &record
+		c.captchas[field] = &record
 		c.mu.Unlock()
 	}
 	if captcha.ExpirationTimer != nil {
 		c.timerMu.Lock()
-		c.timers[userID] = captcha.ExpirationTimer
+		c.timers[field] = captcha.ExpirationTimer
 		c.timerMu.Unlock()
 	}
 }
 
-// DeleteCaptcha removes a pending captcha and its associated timer.
-func (c *Cache) DeleteCaptcha(userID int64) {
+// DeleteCaptcha removes the pending captcha of a user in chatID and its
+// associated timer.
+func (c *Cache) DeleteCaptcha(chatID, userID int64) {
+	field := memberField(chatID, userID)
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixCaptcha, userID)
-		if err := c.client.Del(c.ctx, key).Err(); err != nil {
-			log.Printf("Cache: delete captcha user %d: %v", userID, err)
+		if err := c.client.Del(c.ctx, keyPrefixCaptcha+field).Err(); err != nil {
+			log.Printf("Cache: delete captcha %s: %v", field, err)
 		}
 	} else {
 		c.mu.Lock()
-		delete(c.captchas, userID)
+		delete(c.captchas, field)
 		c.mu.Unlock()
 	}
 	c.timerMu.Lock()
-	delete(c.timers, userID)
+	delete(c.timers, field)
 	c.timerMu.Unlock()
 }
 
-// HasCaptcha reports whether a captcha is pending for the given user.
-func (c *Cache) HasCaptcha(userID int64) bool {
+// HasCaptcha reports whether a captcha is pending for the given user in
+// chatID.
+func (c *Cache) HasCaptcha(chatID, userID int64) bool {
+	field := memberField(chatID, userID)
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixCaptcha, userID)
-		n, err := c.client.Exists(c.ctx, key).Result()
+		n, err := c.client.Exists(c.ctx, keyPrefixCaptcha+field).Result()
 		if err != nil {
-			log.Printf("Cache: exists captcha user %d: %v", userID, err)
+			log.Printf("Cache: exists captcha %s: %v", field, err)
 			return false
 		}
 		return n > 0
 	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	_, ok := c.captchas[userID]
+	_, ok := c.captchas[field]
 	return ok
 }
 
-// UpdateCaptchaJoinMsgID sets JoinMsgID on a pending captcha if it was zero.
-func (c *Cache) UpdateCaptchaJoinMsgID(userID int64, joinMsgID int) {
+// UpdateCaptchaJoinMsgID sets JoinMsgID on the pending captcha of a user in
+// chatID if it was zero.
+func (c *Cache) UpdateCaptchaJoinMsgID(chatID, userID int64, joinMsgID int) {
 	if joinMsgID == 0 {
 		return
 	}
-	pending, ok := c.GetCaptcha(userID)
+	pending, ok := c.GetCaptcha(chatID, userID)
 	if !ok || pending.JoinMsgID != 0 {
 		return
 	}
 	pending.JoinMsgID = joinMsgID
 	var remaining time.Duration
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixCaptcha, userID)
-		remaining, _ = c.client.TTL(c.ctx, key).Result()
+		remaining, _ = c.client.TTL(c.ctx, keyPrefixCaptcha+memberField(chatID, userID)).Result()
 	}
-	c.SetCaptcha(userID, pending, remaining)
+	c.SetCaptcha(chatID, userID, pending, remaining)
 }
 
 // ── Join processed cache ──────────────────────────────────────────────────────
 
-// GetJoinEntry returns the join deduplication record for a user.
-func (c *Cache) GetJoinEntry(userID int64) (*JoinProcessedEntry, bool) {
+// GetJoinEntry returns the join deduplication record for a user in chatID.
+func (c *Cache) GetJoinEntry(chatID, userID int64) (*JoinProcessedEntry, bool) {
+	field := memberField(chatID, userID)
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixJoin, userID)
-		val, err := c.client.Get(c.ctx, key).Result()
+		val, err := c.client.Get(c.ctx, keyPrefixJoin+field).Result()
 		if err == redis.Nil {
 			return nil, false
 		} else if err != nil {
-			log.Printf("Cache: get join entry user %d: %v", userID, err)
+			log.Printf("Cache: get join entry %s: %v", field, err)
 			return nil, false
 		}
 		var entry JoinProcessedEntry
 		if err := json.Unmarshal([]byte(val), &entry); err != nil {
-			log.Printf("Cache: unmarshal join entry user %d: %v", userID, err)
+			log.Printf("Cache: unmarshal join entry %s: %v", field, err)
 			return nil, false
 		}
 		return &entry, true
 	}
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	entry, ok := c.joins[userID]
+	entry, ok := c.joins[field]
 	if !ok {
 		return nil, false
 	}
@@ -621,44 +741,46 @@ func (c *Cache) GetJoinEntry(userID int64) (*JoinProcessedEntry, bool) {
 	return &cp, true
 }
 
-// SetJoinEntry stores a join deduplication record with a 1-minute TTL.
-func (c *Cache) SetJoinEntry(userID int64, entry *JoinProcessedEntry) {
+// SetJoinEntry stores a join deduplication record for a user in chatID with a
+// 1-minute TTL.
+func (c *Cache) SetJoinEntry(chatID, userID int64, entry *JoinProcessedEntry) {
+	field := memberField(chatID, userID)
 	if c.client != nil {
-		key := fmt.Sprintf("%s%d", keyPrefixJoin, userID)
 		data, err := json.Marshal(entry)
 		if err != nil {
-			log.Printf("Cache: marshal join entry user %d: %v", userID, err)
+			log.Printf("Cache: marshal join entry %s: %v", field, err)
 			return
 		}
-		if err := c.client.Set(c.ctx, key, data, joinTTL).Err(); err != nil {
-			log.Printf("Cache: set join entry user %d: %v", userID, err)
+		if err := c.client.Set(c.ctx, keyPrefixJoin+field, data, joinTTL).Err(); err != nil {
+			log.Printf("Cache: set join entry %s: %v", field, err)
 		}
 		return
 	}
 	c.mu.Lock()
-	c.joins[userID] = entry
+	c.joins[field] = entry
 	c.mu.Unlock()
 }
 
-// UpdateJoinEntryBanned marks a join record as banned.
-func (c *Cache) UpdateJoinEntryBanned(userID int64) {
-	entry, ok := c.GetJoinEntry(userID)
+// UpdateJoinEntryBanned marks the join record of a user in chatID as banned.
+func (c *Cache) UpdateJoinEntryBanned(chatID, userID int64) {
+	entry, ok := c.GetJoinEntry(chatID, userID)
 	if !ok {
 		return
 	}
 	entry.IsBanned = true
-	c.SetJoinEntry(userID, entry)
+	c.SetJoinEntry(chatID, userID, entry)
 }
 
-// UpdateJoinEntryMsgID sets JoinMsgID on an existing join record if it was zero.
-func (c *Cache) UpdateJoinEntryMsgID(userID int64, joinMsgID int) (*JoinProcessedEntry, bool) {
-	entry, ok := c.GetJoinEntry(userID)
+// UpdateJoinEntryMsgID sets JoinMsgID on the existing join record of a user in
+// chatID if it was zero.
+func (c *Cache) UpdateJoinEntryMsgID(chatID, userID int64, joinMsgID int) (*JoinProcessedEntry, bool) {
+	entry, ok := c.GetJoinEntry(chatID, userID)
 	if !ok {
 		return nil, false
 	}
 	if joinMsgID != 0 && entry.JoinMsgID == 0 {
 		entry.JoinMsgID = joinMsgID
-		c.SetJoinEntry(userID, entry)
+		c.SetJoinEntry(chatID, userID, entry)
 	}
 	return entry, true
 }
@@ -678,3 +800,696 @@ func (c *Cache) CleanupJoinEntries() {
 	}
 }
 
+// ── Dashboard roles ───────────────────────────────────────────────────────────
+
+// GetRole returns the dashboard role assigned to a user.
+func (c *Cache) GetRole(userID int64) (Role, bool) {
+	if c.client != nil {
+		val, err := c.client.HGet(c.ctx, keyRoles, strconv.FormatInt(userID, 10)).Result()
+		if err == redis.Nil {
+			return RoleNone, false
+		} else if err != nil {
+			log.Printf("Cache: get role user %d: %v", userID, err)
+			return RoleNone, false
+		}
+		return Role(val), true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	role, ok := c.roles[userID]
+	return role, ok
+}
+
+// SetRole assigns a dashboard role to a user.
+func (c *Cache) SetRole(userID int64, role Role) {
+	if c.client != nil {
+		if err := c.client.HSet(c.ctx, keyRoles, strconv.FormatInt(userID, 10), string(role)).Err(); err != nil {
+			log.Printf("Cache: set role user %d: %v", userID, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.roles[userID] = role
+	c.mu.Unlock()
+}
+
+// DeleteRole revokes a user's dashboard role.
+func (c *Cache) DeleteRole(userID int64) {
+	if c.client != nil {
+		if err := c.client.HDel(c.ctx, keyRoles, strconv.FormatInt(userID, 10)).Err(); err != nil {
+			log.Printf("Cache: delete role user %d: %v", userID, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	delete(c.roles, userID)
+	c.mu.Unlock()
+}
+
+// GetAllRoles returns every assigned dashboard role keyed by user ID.
+func (c *Cache) GetAllRoles() map[int64]Role {
+	if c.client != nil {
+		vals, err := c.client.HGetAll(c.ctx, keyRoles).Result()
+		if err != nil {
+			log.Printf("Cache: list roles: %v", err)
+			return nil
+		}
+		result := make(map[int64]Role, len(vals))
+		for idStr, role := range vals {
+			userID, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil {
+				continue
+			}
+			result[userID] = Role(role)
+		}
+		return result
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[int64]Role, len(c.roles))
+	for userID, role := range c.roles {
+		result[userID] = role
+	}
+	return result
+}
+
+// ── Audit log ─────────────────────────────────────────────────────────────────
+
+// AddAuditEvent prepends event to the audit log (capped at maxSize).
+func (c *Cache) AddAuditEvent(event AuditEvent, maxSize int) {
+	if c.client != nil {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Cache: marshal audit event: %v", err)
+			return
+		}
+		pipe := c.client.TxPipeline()
+		pipe.LPush(c.ctx, keyAudit, data)
+		pipe.LTrim(c.ctx, keyAudit, 0, int64(maxSize-1))
+		if _, err := pipe.Exec(c.ctx); err != nil {
+			log.Printf("Cache: add audit event: %v", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.audit = append([]AuditEvent{event}, c.audit...)
+	if len(c.audit) > maxSize {
+		c.audit = c.audit[:maxSize]
+	}
+}
+
+// GetAuditEvents returns up to limit audit events, newest first.
+func (c *Cache) GetAuditEvents(limit int) []AuditEvent {
+	if c.client != nil {
+		vals, err := c.client.LRange(c.ctx, keyAudit, 0, int64(limit-1)).Result()
+		if err != nil {
+			log.Printf("Cache: list audit events: %v", err)
+			return nil
+		}
+		events := make([]AuditEvent, 0, len(vals))
+		for _, val := range vals {
+			var event AuditEvent
+			if err := json.Unmarshal([]byte(val), &event); err != nil {
+				log.Printf("Cache: unmarshal audit event: %v", err)
+				continue
+			}
+			events = append(events, event)
+		}
+		return events
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if limit > len(c.audit) {
+		limit = len(c.audit)
+	}
+	out := make([]AuditEvent, limit)
+	copy(out, c.audit[:limit])
+	return out
+}
+
+// ── Settings snapshots ────────────────────────────────────────────────────────
+
+// AddSnapshot prepends snapshot to the settings history (capped at maxSize).
+func (c *Cache) AddSnapshot(snapshot SettingsSnapshot, maxSize int) {
+	if c.client != nil {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			log.Printf("Cache: marshal snapshot: %v", err)
+			return
+		}
+		pipe := c.client.TxPipeline()
+		pipe.LPush(c.ctx, keySnapshots, data)
+		pipe.LTrim(c.ctx, keySnapshots, 0, int64(maxSize-1))
+		if _, err := pipe.Exec(c.ctx); err != nil {
+			log.Printf("Cache: add snapshot: %v", err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshots = append([]SettingsSnapshot{snapshot}, c.snapshots...)
+	if len(c.snapshots) > maxSize {
+		c.snapshots = c.snapshots[:maxSize]
+	}
+}
+
+// GetSnapshots returns up to limit settings snapshots, newest first.
+func (c *Cache) GetSnapshots(limit int) []SettingsSnapshot {
+	if c.client != nil {
+		vals, err := c.client.LRange(c.ctx, keySnapshots, 0, int64(limit-1)).Result()
+		if err != nil {
+			log.Printf("Cache: list snapshots: %v", err)
+			return nil
+		}
+		snapshots := make([]SettingsSnapshot, 0, len(vals))
+		for _, val := range vals {
+			var snapshot SettingsSnapshot
+			if err := json.Unmarshal([]byte(val), &snapshot); err != nil {
+				log.Printf("Cache: unmarshal snapshot: %v", err)
+				continue
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		return snapshots
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if limit > len(c.snapshots) {
+		limit = len(c.snapshots)
+	}
+	out := make([]SettingsSnapshot, limit)
+	copy(out, c.snapshots[:limit])
+	return out
+}
+
+// GetSnapshot returns the settings snapshot with the given ID.
+func (c *Cache) GetSnapshot(id string) (SettingsSnapshot, bool) {
+	for _, snapshot := range c.GetSnapshots(maxSnapshots) {
+		if snapshot.ID == id {
+			return snapshot, true
+		}
+	}
+	return SettingsSnapshot{}, false
+}
+
+// ── Modmail ───────────────────────────────────────────────────────────────────
+
+// messageField identifies message msgID of chatID in cache keys.
+func messageField(chatID int64, msgID int) string {
+	return fmt.Sprintf("%d:%d", chatID, msgID)
+}
+
+// SetModmailUser records that message msgID in the admin chat chatID was
+// forwarded from a private chat with userID, for 30 days.
+func (c *Cache) SetModmailUser(chatID int64, msgID int, userID int64) {
+	field := messageField(chatID, msgID)
+	if c.client != nil {
+		if err := c.client.Set(c.ctx, keyPrefixModmail+field, userID, modmailTTL).Err(); err != nil {
+			log.Printf("Cache: set modmail message %s: %v", field, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.modmail.set(field, userID, modmailTTL)
+	c.mu.Unlock()
+}
+
+// GetModmailUser returns the user whose private message was forwarded as
+// message msgID in the admin chat chatID.
+func (c *Cache) GetModmailUser(chatID int64, msgID int) (int64, bool) {
+	field := messageField(chatID, msgID)
+	if c.client != nil {
+		userID, err := c.client.Get(c.ctx, keyPrefixModmail+field).Int64()
+		if err == redis.Nil {
+			return 0, false
+		} else if err != nil {
+			log.Printf("Cache: get modmail message %s: %v", field, err)
+			return 0, false
+		}
+		return userID, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.modmail.get(field)
+}
+
+// SetModmailBlocked blocks or unblocks a user from modmail.
+func (c *Cache) SetModmailBlocked(userID int64, blocked bool) {
+	if c.client != nil {
+		var err error
+		if blocked {
+			err = c.client.SAdd(c.ctx, keyModmailBlocked, userID).Err()
+		} else {
+			err = c.client.SRem(c.ctx, keyModmailBlocked, userID).Err()
+		}
+		if err != nil {
+			log.Printf("Cache: set modmail blocked user %d: %v", userID, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	if blocked {
+		c.blocked[userID] = true
+	} else {
+		delete(c.blocked, userID)
+	}
+	c.mu.Unlock()
+}
+
+// IsModmailBlocked reports whether a user is blocked from modmail.
+func (c *Cache) IsModmailBlocked(userID int64) bool {
+	if c.client != nil {
+		blocked, err := c.client.SIsMember(c.ctx, keyModmailBlocked, userID).Result()
+		if err != nil {
+			log.Printf("Cache: get modmail blocked user %d: %v", userID, err)
+		}
+		return blocked
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blocked[userID]
+}
+
+// ── Modmail tickets ───────────────────────────────────────────────────────────
+
+// SetTicket stores the modmail ticket of ticket.UserID.
+func (c *Cache) SetTicket(ticket Ticket) {
+	if c.client != nil {
+		data, err := json.Marshal(ticket)
+		if err != nil {
+			log.Printf("Cache: marshal ticket user %d: %v", ticket.UserID, err)
+			return
+		}
+		pipe := c.client.TxPipeline()
+		pipe.HSet(c.ctx, keyTickets, strconv.FormatInt(ticket.UserID, 10), data)
+		pipe.HSet(c.ctx, keyTicketThreads, messageField(ticket.ChatID, ticket.ThreadID), ticket.UserID)
+		if _, err := pipe.Exec(c.ctx); err != nil {
+			log.Printf("Cache: set ticket user %d: %v", ticket.UserID, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.tickets[ticket.UserID] = ticket
+	c.mu.Unlock()
+}
+
+// GetTicket returns the modmail ticket of a user.
+func (c *Cache) GetTicket(userID int64) (Ticket, bool) {
+	if c.client != nil {
+		val, err := c.client.HGet(c.ctx, keyTickets, strconv.FormatInt(userID, 10)).Result()
+		if err == redis.Nil {
+			return Ticket{}, false
+		} else if err != nil {
+			log.Printf("Cache: get ticket user %d: %v", userID, err)
+			return Ticket{}, false
+		}
+		var ticket Ticket
+		if err := json.Unmarshal([]byte(val), &ticket); err != nil {
+			log.Printf("Cache: unmarshal ticket user %d: %v", userID, err)
+			return Ticket{}, false
+		}
+		return ticket, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ticket, ok := c.tickets[userID]
+	return ticket, ok
+}
+
+// GetTicketByThread returns the modmail ticket held in forum topic threadID
+// of chatID.
+func (c *Cache) GetTicketByThread(chatID int64, threadID int) (Ticket, bool) {
+	if c.client != nil {
+		userID, err := c.client.HGet(c.ctx, keyTicketThreads, messageField(chatID, threadID)).Int64()
+		if err == redis.Nil {
+			return Ticket{}, false
+		} else if err != nil {
+			log.Printf("Cache: get ticket thread %d in %d: %v", threadID, chatID, err)
+			return Ticket{}, false
+		}
+		ticket, ok := c.GetTicket(userID)
+		if !ok || ticket.ChatID != chatID || ticket.ThreadID != threadID {
+			return Ticket{}, false
+		}
+		return ticket, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, ticket := range c.tickets {
+		if ticket.ChatID == chatID && ticket.ThreadID == threadID {
+			return ticket, true
+		}
+	}
+	return Ticket{}, false
+}
+
+// GetTickets returns every modmail ticket.
+func (c *Cache) GetTickets() []Ticket {
+	if c.client != nil {
+		vals, err := c.client.HGetAll(c.ctx, keyTickets).Result()
+		if err != nil {
+			log.Printf("Cache: list tickets: %v", err)
+			return nil
+		}
+		tickets := make([]Ticket, 0, len(vals))
+		for userID, val := range vals {
+			var ticket Ticket
+			if err := json.Unmarshal([]byte(val), &ticket); err != nil {
+				log.Printf("Cache: unmarshal ticket user %s: %v", userID, err)
+				continue
+			}
+			tickets = append(tickets, ticket)
+		}
+		return tickets
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	tickets := make([]Ticket, 0, len(c.tickets))
+	for _, ticket := range c.tickets {
+		tickets = append(tickets, ticket)
+	}
+	return tickets
+}
+
+// ── Channel post copies ───────────────────────────────────────────────────────
+
+// SetPostCopies stores the messages sent by routes for channel post msgID of
+// chatID, for 30 days.
+func (c *Cache) SetPostCopies(chatID int64, msgID int, copies []PostCopy) {
+	if c.client != nil {
+		key := keyPrefixPostCopy + messageField(chatID, msgID)
+		data, err := json.Marshal(copies)
+		if err != nil {
+			log.Printf("Cache: marshal post copies %s: %v", key, err)
+			return
+		}
+		if err := c.client.Set(c.ctx, key, data, postCopiesTTL).Err(); err != nil {
+			log.Printf("Cache: set post copies %s: %v", key, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.copies.set(messageField(chatID, msgID), copies, postCopiesTTL)
+	c.mu.Unlock()
+}
+
+// GetPostCopies returns the messages sent by routes for channel post msgID of
+// chatID.
+func (c *Cache) GetPostCopies(chatID int64, msgID int) ([]PostCopy, bool) {
+	if c.client != nil {
+		key := keyPrefixPostCopy + messageField(chatID, msgID)
+		val, err := c.client.Get(c.ctx, key).Result()
+		if err == redis.Nil {
+			return nil, false
+		} else if err != nil {
+			log.Printf("Cache: get post copies %s: %v", key, err)
+			return nil, false
+		}
+		var copies []PostCopy
+		if err := json.Unmarshal([]byte(val), &copies); err != nil {
+			log.Printf("Cache: unmarshal post copies %s: %v", key, err)
+			return nil, false
+		}
+		return copies, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	copies, ok := c.copies.get(messageField(chatID, msgID))
+	return slices.Clone(copies), ok
+}
+
+// DeletePostCopies forgets the messages sent for channel post msgID of chatID.
+func (c *Cache) DeletePostCopies(chatID int64, msgID int) {
+	if c.client != nil {
+		key := keyPrefixPostCopy + messageField(chatID, msgID)
+		if err := c.client.Del(c.ctx, key).Err(); err != nil {
+			log.Printf("Cache: delete post copies %s: %v", key, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.copies.delete(messageField(chatID, msgID))
+	c.mu.Unlock()
+}
+
+// ── Auto pins ─────────────────────────────────────────────────────────────────
+
+// GetPins returns the messages auto-pinned in chatID, oldest first.
+func (c *Cache) GetPins(chatID int64) []AutoPin {
+	if c.client != nil {
+		val, err := c.client.HGet(c.ctx, keyPins, strconv.FormatInt(chatID, 10)).Result()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			log.Printf("Cache: get pins chat %d: %v", chatID, err)
+			return nil
+		}
+		var pins []AutoPin
+		if err := json.Unmarshal([]byte(val), &pins); err != nil {
+			log.Printf("Cache: unmarshal pins chat %d: %v", chatID, err)
+			return nil
+		}
+		return pins
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.pins[chatID])
+}
+
+// SetPins stores the messages auto-pinned in chatID.
+func (c *Cache) SetPins(chatID int64, pins []AutoPin) {
+	if c.client != nil {
+		field := strconv.FormatInt(chatID, 10)
+		var err error
+		if len(pins) == 0 {
+			err = c.client.HDel(c.ctx, keyPins, field).Err()
+		} else {
+			var data []byte
+			if data, err = json.Marshal(pins); err == nil {
+				err = c.client.HSet(c.ctx, keyPins, field, data).Err()
+			}
+		}
+		if err != nil {
+			log.Printf("Cache: set pins chat %d: %v", chatID, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(pins) == 0 {
+		delete(c.pins, chatID)
+		return
+	}
+	c.pins[chatID] = slices.Clone(pins)
+}
+
+// GetPinnedChats returns the chats with auto-pinned messages.
+func (c *Cache) GetPinnedChats() []int64 {
+	if c.client != nil {
+		fields, err := c.client.HKeys(c.ctx, keyPins).Result()
+		if err != nil {
+			log.Printf("Cache: list pinned chats: %v", err)
+			return nil
+		}
+		var chatIDs []int64
+		for _, field := range fields {
+			if chatID, err := strconv.ParseInt(field, 10, 64); err == nil {
+				chatIDs = append(chatIDs, chatID)
+			}
+		}
+		return chatIDs
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return sortedIDs(c.pins)
+}
+
+// ── Delivery queue ────────────────────────────────────────────────────────────
+
+// QueuePost adds post to the delivery queue, replacing the queued copy of
+// the same post.
+func (c *Cache) QueuePost(post QueuedPost) {
+	field := messageField(post.ChatID(), post.MessageID())
+	if c.client != nil {
+		data, err := json.Marshal(post)
+		if err == nil {
+			err = c.client.HSet(c.ctx, keyDeliveries, field, data).Err()
+		}
+		if err != nil {
+			log.Printf("Cache: queue post %s: %v", field, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue[field] = post
+}
+
+// GetQueuedPost returns the queued post msgID of chatID.
+func (c *Cache) GetQueuedPost(chatID int64, msgID int) (QueuedPost, bool) {
+	field := messageField(chatID, msgID)
+	if c.client != nil {
+		val, err := c.client.HGet(c.ctx, keyDeliveries, field).Result()
+		if err == redis.Nil {
+			return QueuedPost{}, false
+		} else if err != nil {
+			log.Printf("Cache: get queued post %s: %v", field, err)
+			return QueuedPost{}, false
+		}
+		var post QueuedPost
+		if err := json.Unmarshal([]byte(val), &post); err != nil || len(post.Messages) == 0 {
+			log.Printf("Cache: unmarshal queued post %s: %v", field, err)
+			return QueuedPost{}, false
+		}
+		return post, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	post, ok := c.queue[field]
+	return post, ok
+}
+
+// GetQueuedPosts returns the delivery queue, in the order the posts are due.
+func (c *Cache) GetQueuedPosts() []QueuedPost {
+	var posts []QueuedPost
+	if c.client != nil {
+		vals, err := c.client.HGetAll(c.ctx, keyDeliveries).Result()
+		if err != nil {
+			log.Printf("Cache: get delivery queue: %v", err)
+			return nil
+		}
+		for field, val := range vals {
+			var post QueuedPost
+			if err := json.Unmarshal([]byte(val), &post); err != nil || len(post.Messages) == 0 {
+				log.Printf("Cache: unmarshal queued post %s: %v", field, err)
+				continue
+			}
+			posts = append(posts, post)
+		}
+	} else {
+		c.mu.RLock()
+		for _, post := range c.queue {
+			posts = append(posts, post)
+		}
+		c.mu.RUnlock()
+	}
+	slices.SortFunc(posts, func(a, b QueuedPost) int {
+		if n := a.Due.Compare(b.Due); n != 0 {
+			return n
+		}
+		return a.QueuedAt.Compare(b.QueuedAt)
+	})
+	return posts
+}
+
+// DeleteQueuedPost removes the post msgID of chatID from the delivery queue.
+// It reports whether the post was queued.
+func (c *Cache) DeleteQueuedPost(chatID int64, msgID int) bool {
+	field := messageField(chatID, msgID)
+	if c.client != nil {
+		n, err := c.client.HDel(c.ctx, keyDeliveries, field).Result()
+		if err != nil {
+			log.Printf("Cache: delete queued post %s: %v", field, err)
+			return false
+		}
+		return n > 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.queue[field]
+	delete(c.queue, field)
+	return ok
+}
+
+// ── Link fixes ────────────────────────────────────────────────────────────────
+
+// SetLinkFix stores the details of message msgID of chatID, sent by link
+// detection, for 2 days.
+func (c *Cache) SetLinkFix(chatID int64, msgID int, fix LinkFix) {
+	if c.client != nil {
+		key := keyPrefixLinkFix + messageField(chatID, msgID)
+		data, err := json.Marshal(fix)
+		if err != nil {
+			log.Printf("Cache: marshal link fix %s: %v", key, err)
+			return
+		}
+		if err := c.client.Set(c.ctx, key, data, linkFixTTL).Err(); err != nil {
+			log.Printf("Cache: set link fix %s: %v", key, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.linkFixes.set(messageField(chatID, msgID), fix, linkFixTTL)
+	c.mu.Unlock()
+}
+
+// GetLinkFix returns the details of message msgID of chatID, sent by link
+// detection.
+func (c *Cache) GetLinkFix(chatID int64, msgID int) (LinkFix, bool) {
+	if c.client != nil {
+		key := keyPrefixLinkFix + messageField(chatID, msgID)
+		val, err := c.client.Get(c.ctx, key).Result()
+		if err == redis.Nil {
+			return LinkFix{}, false
+		} else if err != nil {
+			log.Printf("Cache: get link fix %s: %v", key, err)
+			return LinkFix{}, false
+		}
+		var fix LinkFix
+		if err := json.Unmarshal([]byte(val), &fix); err != nil {
+			log.Printf("Cache: unmarshal link fix %s: %v", key, err)
+			return LinkFix{}, false
+		}
+		return fix, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.linkFixes.get(messageField(chatID, msgID))
+}
+
+// DeleteLinkFix forgets message msgID of chatID, sent by link detection.
+func (c *Cache) DeleteLinkFix(chatID int64, msgID int) {
+	if c.client != nil {
+		key := keyPrefixLinkFix + messageField(chatID, msgID)
+		if err := c.client.Del(c.ctx, key).Err(); err != nil {
+			log.Printf("Cache: delete link fix %s: %v", key, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.linkFixes.delete(messageField(chatID, msgID))
+	c.mu.Unlock()
+}
+
+// ── Resolved links ────────────────────────────────────────────────────────────
+
+// SetResolvedLink remembers where the short link link leads, for 7 days.
+func (c *Cache) SetResolvedLink(link, resolved string) {
+	if c.client != nil {
+		if err := c.client.Set(c.ctx, keyPrefixResolved+link, resolved, resolvedTTL).Err(); err != nil {
+			log.Printf("Cache: set resolved link %s: %v", link, err)
+		}
+		return
+	}
+	c.mu.Lock()
+	c.resolved.set(link, resolved, resolvedTTL)
+	c.mu.Unlock()
+}
+
+// GetResolvedLink returns where the short link link leads.
+func (c *Cache) GetResolvedLink(link string) (string, bool) {
+	if c.client != nil {
+		val, err := c.client.Get(c.ctx, keyPrefixResolved+link).Result()
+		if err == redis.Nil {
+			return "", false
+		} else if err != nil {
+			log.Printf("Cache: get resolved link %s: %v", link, err)
+			return "", false
+		}
+		return val, true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resolved.get(link)
+}
@@ -1,7 +1,9 @@
 package telegram
 
 import (
+	"strconv"
 	"testing"
+	"time"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
@@ -44,3 +46,31 @@ func TestAddMessageToCache(t *testing.T) {
 		t.Errorf("Expected message text 'Hello', got '%s'", msgs[0].Text)
 	}
 }
+
+func TestExpiringMap(t *testing.T) {
+	var m expiringMap[int]
+	m.set("expired", 1, -time.Second)
+	m.set("valid", 2, time.Hour)
+
+	if _, ok := m.get("expired"); ok {
+		t.Error("get() returned an expired entry")
+	}
+	if value, ok := m.get("valid"); !ok || value != 2 {
+		t.Errorf("get() = %d, %v, want 2, true", value, ok)
+	}
+
+	for i := 0; i < 2*minSweepSize; i++ {
+		m.set(strconv.Itoa(i), i, -time.Second)
+	}
+	if len(m.entries) > 2*minSweepSize {
+		t.Errorf("expired entries were not swept: %d entries", len(m.entries))
+	}
+	if _, ok := m.get("valid"); !ok {
+		t.Error("sweeping removed a valid entry")
+	}
+
+	m.delete("valid")
+	if _, ok := m.get("valid"); ok {
+		t.Error("delete() left the entry")
+	}
+}
@@ -90,16 +90,15 @@ func SendCaptcha(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 		Bytes: captchaImage.EncodedPNG(),
 	}
 
-	escarbot.StateMutex.RLock()
-	timeout := escarbot.CaptchaTimeout
-	captchaText := escarbot.CaptchaText
-	escarbot.StateMutex.RUnlock()
+	group, _ := GetGroupSettings(escarbot, chatID)
+	timeout := group.CaptchaTimeout
+	captchaText := group.CaptchaText
 
 	photo := tgbotapi.NewPhoto(chatID, file)
 	if captchaText == "" {
 		photo.Caption = fmt.Sprintf("Welcome %s! Please solve the captcha within %d seconds to join the group.", html.EscapeString(user.FirstName), timeout)
 	} else {
-		photo.Caption = replacePlaceholders(escarbot, captchaText, user)
+		photo.Caption = replacePlaceholders(captchaText, chatID, user)
 		photo.Caption = strings.ReplaceAll(photo.Caption, "{TIMEOUT}", strconv.Itoa(timeout))
 	}
 	photo.ParseMode = "HTML"
@@ -134,12 +133,12 @@ func SendCaptcha(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 	}
 
 	// Stop existing timer if user rejoined quickly.
-	if existing, ok := escarbot.Cache.GetCaptcha(user.ID); ok && existing.ExpirationTimer != nil {
+	if existing, ok := escarbot.Cache.GetCaptcha(chatID, user.ID); ok && existing.ExpirationTimer != nil {
 		existing.ExpirationTimer.Stop()
 	}
 
 	timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
-		handleCaptchaTimeout(escarbot, user.ID)
+		handleCaptchaTimeout(escarbot, chatID, user.ID)
 	})
 
 	pending := &PendingCaptcha{
@@ -153,31 +152,60 @@ func SendCaptcha(escarbot *EscarBot, chatID int64, user tgbotapi.User, joinMsgID
 		ExpirationTimer: timer,
 	}
 	// Give the cache record a slightly longer TTL than the timer to avoid race conditions.
-	escarbot.Cache.SetCaptcha(user.ID, pending, time.Duration(timeout+30)*time.Second)
+	escarbot.Cache.SetCaptcha(chatID, user.ID, pending, time.Duration(timeout+30)*time.Second)
 	log.Printf("Captcha sent to user %d in chat %d, answer: %s", user.ID, chatID, answerStr)
 }
 
-func isUserPendingCaptcha(escarbot *EscarBot, userID int64) bool {
-	return escarbot.Cache.HasCaptcha(userID)
+func isUserPendingCaptcha(escarbot *EscarBot, chatID, userID int64) bool {
+	return escarbot.Cache.HasCaptcha(chatID, userID)
 }
 
-func handleCaptchaTimeout(escarbot *EscarBot, userID int64) {
-	pending, exists := escarbot.Cache.GetCaptcha(userID)
+func handleCaptchaTimeout(escarbot *EscarBot, chatID, userID int64) {
+	pending, exists := escarbot.Cache.GetCaptcha(chatID, userID)
 	if !exists {
 		return
 	}
-	escarbot.Cache.DeleteCaptcha(userID)
+	escarbot.Cache.DeleteCaptcha(chatID, userID)
 
-	log.Printf("User %d timed out on captcha", userID)
+	log.Printf("User %d timed out on captcha in chat %d", userID, chatID)
 
 	user := tgbotapi.User{ID: pending.UserID, FirstName: pending.UserFirstName}
 	banAndCleanup(escarbot, pending.ChatID, user, pending.JoinMsgID, pending.CaptchaMsgID)
 }
 
+// callbackHandlers handle the callback queries whose data starts with their
+// prefix.
+var callbackHandlers = []struct {
+	prefix string
+	handle func(*EscarBot, *tgbotapi.CallbackQuery)
+}{
+	{"captcha:", HandleCaptchaCallback},
+	{linkUndoData, handleLinkUndo},
+	{linkDeletePrefix, handleLinkDelete},
+}
+
+// HandleCallback passes a callback query to the handler of its data prefix.
+// Queries nobody handles are answered, so that their button stops loading.
+func HandleCallback(escarbot *EscarBot, callback *tgbotapi.CallbackQuery) {
+	for _, h := range callbackHandlers {
+		if strings.HasPrefix(callback.Data, h.prefix) {
+			h.handle(escarbot, callback)
+			return
+		}
+	}
+	escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
+
 func HandleCaptchaCallback(escarbot *EscarBot, callback *tgbotapi.CallbackQuery) {
 	if callback.Data == "" || !strings.HasPrefix(callback.Data, "captcha:") {
 		return
 	}
+	if callback.Message == nil {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	// Captchas are sent in the group the user joined.
+	chatID := callback.Message.Chat.ID
 
 	parts := strings.Split(callback.Data, ":")
 	if len(parts) != 3 {
@@ -193,22 +221,21 @@ func HandleCaptchaCallback(escarbot *EscarBot, callback *tgbotapi.CallbackQuery)
 		return
 	}
 
-	pending, exists := escarbot.Cache.GetCaptcha(targetUserID)
+	pending, exists := escarbot.Cache.GetCaptcha(chatID, targetUserID)
 	if !exists {
 		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
 		escarbot.Bot.Request(callbackConfig)
 		return
 	}
 
-	escarbot.StateMutex.RLock()
-	maxRetries := escarbot.CaptchaMaxRetries
-	escarbot.StateMutex.RUnlock()
+	group, _ := GetGroupSettings(escarbot, pending.ChatID)
+	maxRetries := group.CaptchaMaxRetries
 
 	if givenAnswer == pending.CorrectAnswer {
 		if pending.ExpirationTimer != nil {
 			pending.ExpirationTimer.Stop()
 		}
-		escarbot.Cache.DeleteCaptcha(targetUserID)
+		escarbot.Cache.DeleteCaptcha(chatID, targetUserID)
 
 		callbackConfig := tgbotapi.NewCallback(callback.ID, "Correct!")
 		escarbot.Bot.Request(callbackConfig)
@@ -216,18 +243,14 @@ func HandleCaptchaCallback(escarbot *EscarBot, callback *tgbotapi.CallbackQuery)
 		unrestrictUser(escarbot, pending.ChatID, targetUserID)
 		deleteMessages(escarbot, pending.ChatID, pending.CaptchaMsgID)
 
-		escarbot.StateMutex.RLock()
-		welcomeEnabled := escarbot.WelcomeMessage
-		escarbot.StateMutex.RUnlock()
-
-		if welcomeEnabled {
+		if group.WelcomeMessage {
 			sendWelcomeMessage(escarbot, pending.ChatID, *callback.From)
 		}
 	} else {
 		if pending.ExpirationTimer != nil {
 			pending.ExpirationTimer.Stop()
 		}
-		escarbot.Cache.DeleteCaptcha(targetUserID)
+		escarbot.Cache.DeleteCaptcha(chatID, targetUserID)
 
 		log.Printf("User %d gave wrong captcha answer: %s (expected %s). Attempt: %d/%d",
 			targetUserID, givenAnswer, pending.CorrectAnswer, pending.Attempts+1, maxRetries+1)
@@ -9,21 +9,24 @@ func TestIsUserPendingCaptcha(t *testing.T) {
 		Cache: NewCache(""), // in-memory mode
 	}
 
-	userID := int64(12345)
+	chatID, userID := int64(-100), int64(12345)
 
-	if isUserPendingCaptcha(bot, userID) {
+	if isUserPendingCaptcha(bot, chatID, userID) {
 		t.Errorf("isUserPendingCaptcha() = true, want false for empty cache")
 	}
 
-	bot.Cache.SetCaptcha(userID, &PendingCaptcha{UserID: userID}, 0)
+	bot.Cache.SetCaptcha(chatID, userID, &PendingCaptcha{UserID: userID}, 0)
 
-	if !isUserPendingCaptcha(bot, userID) {
+	if !isUserPendingCaptcha(bot, chatID, userID) {
 		t.Errorf("isUserPendingCaptcha() = false, want true for user in cache")
 	}
+	if isUserPendingCaptcha(bot, -200, userID) {
+		t.Errorf("isUserPendingCaptcha() = true, want false for another chat")
+	}
 
-	bot.Cache.DeleteCaptcha(userID)
+	bot.Cache.DeleteCaptcha(chatID, userID)
 
-	if isUserPendingCaptcha(bot, userID) {
+	if isUserPendingCaptcha(bot, chatID, userID) {
 		t.Errorf("isUserPendingCaptcha() = true, want false after deletion")
 	}
 }
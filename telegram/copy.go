@@ -0,0 +1,227 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// captionMediaTypes are the RouteMediaTypes whose messages can have a caption.
+var captionMediaTypes = []string{"photo", "video", "animation", "audio", "document", "voice"}
+
+// privateLink returns the t.me link to message or topic id of chatID, which
+// only works for the members of the chat.
+func privateLink(chatID int64, id int) string {
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(strconv.FormatInt(chatID, 10), "-100"), id)
+}
+
+// postLink returns the t.me link to a channel post.
+func postLink(message *tgbotapi.Message) string {
+	if message.Chat.UserName != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", message.Chat.UserName, message.MessageID)
+	}
+	return privateLink(message.Chat.ID, message.MessageID)
+}
+
+// renderPostTemplate fills the placeholders of an HTML template with the
+// details of a channel post: {CHANNEL_TITLE}, {POST_LINK} and {TEXT}, the
+// post text or caption with its formatting.
+func renderPostTemplate(template string, message *tgbotapi.Message) string {
+	text, entities := message.Text, message.Entities
+	if text == "" {
+		text, entities = message.Caption, message.CaptionEntities
+	}
+	return strings.NewReplacer(
+		"{CHANNEL_TITLE}", html.EscapeString(message.Chat.Title),
+		"{POST_LINK}", postLink(message),
+		"{TEXT}", entitiesToHTML(text, entities),
+	).Replace(template)
+}
+
+// entityTags returns the HTML tags rendering a formatting entity, or empty
+// strings for entities Telegram detects by itself, like URLs and hashtags.
+func entityTags(e tgbotapi.MessageEntity) (open, close string) {
+	switch e.Type {
+	case "bold":
+		return "<b>", "</b>"
+	case "italic":
+		return "<i>", "</i>"
+	case "underline":
+		return "<u>", "</u>"
+	case "strikethrough":
+		return "<s>", "</s>"
+	case "spoiler":
+		return "<tg-spoiler>", "</tg-spoiler>"
+	case "code":
+		return "<code>", "</code>"
+	case "pre":
+		if e.Language != "" {
+			return `<pre><code class="language-` + html.EscapeString(e.Language) + `">`, "</code></pre>"
+		}
+		return "<pre>", "</pre>"
+	case "text_link":
+		return `<a href="` + html.EscapeString(e.URL) + `">`, "</a>"
+	case "text_mention":
+		if e.User != nil {
+			return `<a href="tg://user?id=` + strconv.FormatInt(e.User.ID, 10) + `">`, "</a>"
+		}
+	case "blockquote":
+		return "<blockquote>", "</blockquote>"
+	case "expandable_blockquote":
+		return "<blockquote expandable>", "</blockquote>"
+	case "custom_emoji":
+		return `<tg-emoji emoji-id="` + html.EscapeString(e.CustomEmojiID) + `">`, "</tg-emoji>"
+	}
+	return "", ""
+}
+
+// entitiesToHTML renders text and its formatting entities as Telegram HTML.
+// Entities that overlap without being nested are split, so that their tags
+// never cross.
+func entitiesToHTML(text string, entities []tgbotapi.MessageEntity) string {
+	// Entity offsets count UTF-16 code units.
+	units := utf16.Encode([]rune(text))
+
+	type span struct {
+		open, close string
+		end         int
+	}
+	starts := make(map[int][]span)
+
+	// Outer entities first, so that they open before the ones nested in them.
+	sorted := slices.Clone(entities)
+	slices.SortStableFunc(sorted, func(a, b tgbotapi.MessageEntity) int {
+		if a.Offset != b.Offset {
+			return a.Offset - b.Offset
+		}
+		return b.Length - a.Length
+	})
+	for _, e := range sorted {
+		open, close := entityTags(e)
+		if open == "" || e.Length <= 0 || e.Offset < 0 || e.Offset+e.Length > len(units) {
+			continue
+		}
+		starts[e.Offset] = append(starts[e.Offset], span{open, close, e.Offset + e.Length})
+	}
+
+	var b strings.Builder
+	var stack []span // open spans, outermost first
+	for i := 0; i <= len(units); {
+		// Closing a span also closes the ones opened after it; those that go
+		// on are opened again right after.
+		if k := slices.IndexFunc(stack, func(s span) bool { return s.end <= i }); k >= 0 {
+			for j := len(stack) - 1; j >= k; j-- {
+				b.WriteString(stack[j].close)
+			}
+			reopened := slices.DeleteFunc(slices.Clone(stack[k:]), func(s span) bool { return s.end <= i })
+			stack = stack[:k]
+			for _, s := range reopened {
+				b.WriteString(s.open)
+				stack = append(stack, s)
+			}
+		}
+		for _, s := range starts[i] {
+			b.WriteString(s.open)
+			stack = append(stack, s)
+		}
+		if i == len(units) {
+			break
+		}
+		n := 1
+		if utf16.IsSurrogate(rune(units[i])) && i+1 < len(units) {
+			n = 2
+		}
+		b.WriteString(html.EscapeString(string(utf16.Decode(units[i : i+n]))))
+		i += n
+	}
+	return b.String()
+}
+
+// postButtons returns the inline keyboard with the route buttons for a
+// channel post, or nil if the route has none.
+func postButtons(route Route, message *tgbotapi.Message) *tgbotapi.InlineKeyboardMarkup {
+	buttons := linkButtons(route.Buttons, func(url string) string {
+		return strings.ReplaceAll(url, "{POST_LINK}", postLink(message))
+	})
+	if len(buttons) == 0 {
+		return nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons...)
+	return &markup
+}
+
+// copyPost copies a channel post as route says, replacing its text or caption
+// with the route template and adding the route buttons. It returns the ID of
+// the copy.
+func copyPost(escarbot *EscarBot, route Route, message *tgbotapi.Message) (int, error) {
+	markup := postButtons(route, message)
+
+	// copyMessage cannot change the text of text posts, so they are sent anew.
+	mediaType := messageMediaType(message)
+	if route.Template != "" && mediaType == "text" {
+		msg := tgbotapi.NewMessage(route.Target, renderPostTemplate(route.Template, message))
+		msg.MessageThreadID = route.ThreadID
+		msg.ParseMode = tgbotapi.ModeHTML
+		if markup != nil {
+			msg.ReplyMarkup = markup
+		}
+		sent, err := escarbot.Bot.Send(msg)
+		return sent.MessageID, err
+	}
+
+	msg := tgbotapi.NewCopyMessage(route.Target, message.Chat.ID, message.MessageID)
+	msg.MessageThreadID = route.ThreadID
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	if route.Template != "" && slices.Contains(captionMediaTypes, mediaType) {
+		msg.Caption = renderPostTemplate(route.Template, message)
+		msg.ParseMode = tgbotapi.ModeHTML
+	}
+	sent, err := escarbot.Bot.CopyMessage(msg)
+	return sent.MessageID, err
+}
+
+// editCopy applies an edit of a channel post to a copy of it made by
+// copyPost. Only the text or caption can follow: stickers, polls and the
+// like are left alone.
+func editCopy(escarbot *EscarBot, route Route, msgID int, message *tgbotapi.Message) error {
+	mediaType := messageMediaType(message)
+	var edit tgbotapi.Chattable
+	switch {
+	case mediaType == "text":
+		msg := tgbotapi.NewEditMessageText(route.Target, msgID, message.Text)
+		msg.Entities = message.Entities
+		if route.Template != "" {
+			msg.Text = renderPostTemplate(route.Template, message)
+			msg.ParseMode = tgbotapi.ModeHTML
+			msg.Entities = nil
+		}
+		msg.ReplyMarkup = postButtons(route, message)
+		edit = msg
+	case slices.Contains(captionMediaTypes, mediaType):
+		// Album items have no buttons, and the template only replaces the
+		// album caption: see sendAlbum.
+		inAlbum := message.MediaGroupID != ""
+		msg := tgbotapi.NewEditMessageCaption(route.Target, msgID, message.Caption)
+		msg.CaptionEntities = message.CaptionEntities
+		if route.Template != "" && (!inAlbum || message.Caption != "") {
+			msg.Caption = renderPostTemplate(route.Template, message)
+			msg.ParseMode = tgbotapi.ModeHTML
+			msg.CaptionEntities = nil
+		}
+		if !inAlbum {
+			msg.ReplyMarkup = postButtons(route, message)
+		}
+		edit = msg
+	default:
+		return nil
+	}
+	_, err := escarbot.Bot.Request(edit)
+	return err
+}
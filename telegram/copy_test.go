@@ -0,0 +1,121 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestEntitiesToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		want     string
+	}{
+		{"plain", "a < b & c", nil, "a &lt; b &amp; c"},
+		{"bold", "hello world", []tgbotapi.MessageEntity{{Type: "bold", Offset: 6, Length: 5}}, "hello <b>world</b>"},
+		{"nested", "bold italic", []tgbotapi.MessageEntity{
+			{Type: "italic", Offset: 5, Length: 6},
+			{Type: "bold", Offset: 0, Length: 11},
+		}, "<b>bold <i>italic</i></b>"},
+		{"adjacent", "ab", []tgbotapi.MessageEntity{
+			{Type: "bold", Offset: 0, Length: 1},
+			{Type: "italic", Offset: 1, Length: 1},
+		}, "<b>a</b><i>b</i>"},
+		{"overlapping", "Hello world", []tgbotapi.MessageEntity{
+			{Type: "bold", Offset: 0, Length: 5},
+			{Type: "italic", Offset: 3, Length: 5},
+		}, "<b>Hel<i>lo</i></b><i> wo</i>rld"},
+		{"same end", "abc", []tgbotapi.MessageEntity{
+			{Type: "bold", Offset: 0, Length: 3},
+			{Type: "italic", Offset: 1, Length: 2},
+		}, "<b>a<i>bc</i></b>"},
+		{"link", "site", []tgbotapi.MessageEntity{{Type: "text_link", Offset: 0, Length: 4, URL: "https://x.y/?a=1&b=2"}}, `<a href="https://x.y/?a=1&amp;b=2">site</a>`},
+		{"utf-16 offsets", "😀 hi #tag", []tgbotapi.MessageEntity{
+			{Type: "bold", Offset: 3, Length: 2},
+			{Type: "hashtag", Offset: 6, Length: 4},
+		}, "😀 <b>hi</b> #tag"},
+		{"out of range", "hi", []tgbotapi.MessageEntity{{Type: "bold", Offset: 1, Length: 5}}, "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entitiesToHTML(tt.text, tt.entities); got != tt.want {
+				t.Errorf("entitiesToHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderPostTemplate(t *testing.T) {
+	post := &tgbotapi.Message{
+		MessageID:       7,
+		Chat:            tgbotapi.Chat{ID: -1001234, Title: "News & More"},
+		Caption:         "Big news",
+		CaptionEntities: []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 3}},
+	}
+
+	got := renderPostTemplate("{TEXT}\n{CHANNEL_TITLE} {POST_LINK}", post)
+	if want := "<b>Big</b> news\nNews &amp; More https://t.me/c/1234/7"; got != want {
+		t.Errorf("renderPostTemplate() = %q, want %q", got, want)
+	}
+
+	post.Chat.UserName = "news"
+	if got := renderPostTemplate("{POST_LINK}", post); got != "https://t.me/news/7" {
+		t.Errorf("renderPostTemplate() of a public channel = %q", got)
+	}
+}
+
+func TestCopyPost(t *testing.T) {
+	var calls []url.Values
+	var methods []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		methods = append(methods, method)
+		calls = append(calls, form)
+		if method == "copyMessage" {
+			return true, `{"message_id":2}`
+		}
+		return true, `{"message_id":2,"date":0,"chat":{"id":1,"type":"group"}}`
+	})
+	escarbot := &EscarBot{Bot: api}
+	route := Route{Target: 100, ThreadID: 5, Copy: true, Template: "{TEXT} via {CHANNEL_TITLE}", Buttons: "Open|{POST_LINK}"}
+	chat := tgbotapi.Chat{ID: -100, Title: "Chan", UserName: "chan"}
+
+	tests := []struct {
+		name       string
+		message    *tgbotapi.Message
+		method     string
+		text       string
+		textField  string
+		hasButtons bool
+	}{
+		{"text", &tgbotapi.Message{MessageID: 1, Chat: chat, Text: "hi"}, "sendMessage", "hi via Chan", "text", true},
+		{"photo", &tgbotapi.Message{MessageID: 1, Chat: chat, Photo: []tgbotapi.PhotoSize{{}}, Caption: "pic"}, "copyMessage", "pic via Chan", "caption", true},
+		{"sticker", &tgbotapi.Message{MessageID: 1, Chat: chat, Sticker: &tgbotapi.Sticker{}}, "copyMessage", "", "caption", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods, calls = nil, nil
+			if _, err := copyPost(escarbot, route, tt.message); err != nil {
+				t.Fatal(err)
+			}
+			if len(methods) != 1 || methods[0] != tt.method {
+				t.Fatalf("called %v, want %s", methods, tt.method)
+			}
+			form := calls[0]
+			if got := form.Get(tt.textField); got != tt.text {
+				t.Errorf("%s = %q, want %q", tt.textField, got, tt.text)
+			}
+			if form.Get("message_thread_id") != "5" {
+				t.Errorf("message_thread_id = %q", form.Get("message_thread_id"))
+			}
+			if got := form.Get("reply_markup"); tt.hasButtons != strings.Contains(got, "https://t.me/chan/1") {
+				t.Errorf("reply_markup = %q", got)
+			}
+		})
+	}
+}
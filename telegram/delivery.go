@@ -0,0 +1,227 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// QuietHours is a daily window, in minutes after midnight, during which
+// channel posts are held. It may wrap around midnight; Start == End means
+// no quiet hours.
+type QuietHours struct {
+	Start, End int
+}
+
+var quietHoursRe = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)-([01]?\d|2[0-3]):([0-5]\d)$`)
+
+// ParseQuietHours parses quiet hours in the form "23:00-08:00". An empty
+// string means no quiet hours.
+func ParseQuietHours(s string) (QuietHours, error) {
+	if s == "" {
+		return QuietHours{}, nil
+	}
+	m := quietHoursRe.FindStringSubmatch(s)
+	if m == nil {
+		return QuietHours{}, fmt.Errorf("%q is not in the form HH:MM-HH:MM", s)
+	}
+	n := make([]int, 4)
+	for i := range n {
+		n[i], _ = strconv.Atoi(m[i+1])
+	}
+	return QuietHours{Start: n[0]*60 + n[1], End: n[2]*60 + n[3]}, nil
+}
+
+// until returns the end of the quiet hours now falls in, in the location of
+// now, or false if now is not in quiet hours.
+func (q QuietHours) until(now time.Time) (time.Time, bool) {
+	minute := now.Hour()*60 + now.Minute()
+	var quiet bool
+	if q.Start < q.End {
+		quiet = minute >= q.Start && minute < q.End
+	} else if q.Start > q.End {
+		quiet = minute >= q.Start || minute < q.End
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	return nextClock(now, q.End/60, q.End%60), true
+}
+
+// nextClock returns the next time at hour:minute, in the location of now. If
+// now falls in that minute, it returns its start, which is not after now.
+func nextClock(now time.Time, hour, minute int) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if now.Sub(t) >= time.Minute {
+		t = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, now.Location())
+	}
+	return t
+}
+
+// scheduleTagRe matches the hashtags asking to forward a post at a given
+// time, like #at1830.
+var scheduleTagRe = regexp.MustCompile(`^at([01]\d|2[0-3])([0-5]\d)$`)
+
+// scheduledTime returns the time asked for by the first #atHHMM hashtag in
+// messages, in the location of now, or false if there is none.
+func scheduledTime(messages []*tgbotapi.Message, now time.Time) (time.Time, bool) {
+	for _, message := range messages {
+		for _, tag := range messageHashtags(message) {
+			if m := scheduleTagRe.FindStringSubmatch(tag); m != nil {
+				hour, _ := strconv.Atoi(m[1])
+				minute, _ := strconv.Atoi(m[2])
+				return nextClock(now, hour, minute), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// QueuedPost is a channel post held until Due.
+type QueuedPost struct {
+	Messages []*tgbotapi.Message `json:"messages"` // the post, or the items of an album
+	Due      time.Time           `json:"due"`
+	QueuedAt time.Time           `json:"queued_at"`
+}
+
+// ChatID returns the channel of q.
+func (q QueuedPost) ChatID() int64 {
+	return q.Messages[0].Chat.ID
+}
+
+// MessageID returns the ID of the post, or of the first item of the album.
+func (q QueuedPost) MessageID() int {
+	return q.Messages[0].MessageID
+}
+
+// Preview describes q for the dashboard.
+func (q QueuedPost) Preview() string {
+	for _, message := range q.Messages {
+		if text := message.Text + message.Caption; text != "" {
+			return truncate(text, 80)
+		}
+	}
+	if len(q.Messages) > 1 {
+		return fmt.Sprintf("[album of %d]", len(q.Messages))
+	}
+	return "[" + messageMediaType(q.Messages[0]) + "]"
+}
+
+// deliveryMu keeps held posts from being sent twice or out of order.
+var deliveryMu sync.Mutex
+
+// location returns the time zone named by name, or UTC if it is unknown.
+func location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown time zone %q, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// deliverPost sends a channel post, or the items of an album, through its
+// routes, unless it asks for a later time with #atHHMM or it arrives during
+// quiet hours: then it is queued until that time.
+func deliverPost(escarbot *EscarBot, messages []*tgbotapi.Message) {
+	now := time.Now()
+	// Posts held until now go out first.
+	releasePosts(escarbot, now)
+
+	escarbot.StateMutex.RLock()
+	quietHours, timezone := escarbot.QuietHours, escarbot.Timezone
+	escarbot.StateMutex.RUnlock()
+	local := now.In(location(timezone))
+
+	due, held := scheduledTime(messages, local)
+	if held && !due.After(local) {
+		// Posted in the very minute it asks for.
+		held = false
+	} else if !held {
+		quiet, err := ParseQuietHours(quietHours)
+		if err != nil {
+			log.Printf("Invalid quiet hours, ignoring them: %v", err)
+		}
+		due, held = quiet.until(local)
+	}
+	if !held {
+		sendPost(escarbot, messages)
+		return
+	}
+
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+	post := QueuedPost{Messages: messages, Due: due, QueuedAt: now}
+	escarbot.Cache.QueuePost(post)
+	log.Printf("Holding post %d from %d until %s", post.MessageID(), post.ChatID(), due.Format(time.RFC3339))
+}
+
+// sendPost sends a channel post, or the items of an album, through its routes.
+func sendPost(escarbot *EscarBot, messages []*tgbotapi.Message) {
+	if messages[0].MediaGroupID != "" {
+		routeAlbum(escarbot, messages)
+		return
+	}
+	routePost(escarbot, messages[0])
+}
+
+// releasePosts sends the queued posts due by now, in order.
+func releasePosts(escarbot *EscarBot, now time.Time) {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+	for _, post := range escarbot.Cache.GetQueuedPosts() {
+		if post.Due.After(now) {
+			break
+		}
+		if escarbot.Cache.DeleteQueuedPost(post.ChatID(), post.MessageID()) {
+			sendPost(escarbot, post.Messages)
+		}
+	}
+}
+
+// SendQueuedPost sends the queued post msgID of chatID right away. It
+// reports whether the post was queued.
+func SendQueuedPost(escarbot *EscarBot, chatID int64, msgID int) bool {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+	post, ok := escarbot.Cache.GetQueuedPost(chatID, msgID)
+	if !ok || !escarbot.Cache.DeleteQueuedPost(chatID, msgID) {
+		return false
+	}
+	sendPost(escarbot, post.Messages)
+	return true
+}
+
+// updateQueuedPost replaces the queued copy of an edited channel post. It
+// reports whether the post was queued.
+func updateQueuedPost(escarbot *EscarBot, message *tgbotapi.Message) bool {
+	deliveryMu.Lock()
+	defer deliveryMu.Unlock()
+	for _, post := range escarbot.Cache.GetQueuedPosts() {
+		for i, m := range post.Messages {
+			if m.Chat.ID == message.Chat.ID && m.MessageID == message.MessageID {
+				post.Messages[i] = message
+				escarbot.Cache.QueuePost(post)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WatchDeliveries sends the queued posts as they become due, checking every
+// interval and starting with the ones that became due while the bot was
+// down. It never returns.
+func WatchDeliveries(escarbot *EscarBot, interval time.Duration) {
+	releasePosts(escarbot, time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		releasePosts(escarbot, now)
+	}
+}
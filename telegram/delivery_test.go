@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestQuietHours(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 10, day, hour, minute, 0, 0, rome) }
+
+	tests := []struct {
+		spec  string
+		now   time.Time
+		until time.Time // zero if not quiet
+	}{
+		{"", at(16, 3, 0), time.Time{}},
+		{"23:00-08:00", at(16, 3, 0), at(16, 8, 0)},
+		{"23:00-08:00", at(16, 23, 30), at(17, 8, 0)},
+		{"23:00-08:00", at(16, 8, 0), time.Time{}},
+		{"23:00-08:00", at(16, 12, 0), time.Time{}},
+		{"13:00-14:30", at(16, 13, 59), at(16, 14, 30)},
+		{"13:00-14:30", at(16, 14, 30), time.Time{}},
+		{"22:00-7:00", at(24, 23, 0), at(25, 7, 0)}, // across the end of daylight saving time
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec+" "+tt.now.Format("15:04"), func(t *testing.T) {
+			quiet, err := ParseQuietHours(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseQuietHours() error = %v", err)
+			}
+			until, ok := quiet.until(tt.now)
+			if ok != !tt.until.IsZero() || !until.Equal(tt.until) {
+				t.Errorf("until() = %v, %v, want %v", until, ok, tt.until)
+			}
+		})
+	}
+
+	for _, spec := range []string{"23:00", "25:00-08:00", "23:00-08:60", "late"} {
+		if _, err := ParseQuietHours(spec); err == nil {
+			t.Errorf("ParseQuietHours(%q) accepted an invalid window", spec)
+		}
+	}
+}
+
+func TestScheduledTime(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 30, 0, time.UTC)
+	tests := []struct {
+		text string
+		want time.Time // zero if not scheduled
+	}{
+		{"#news", time.Time{}},
+		{"#at1830", time.Date(2026, 10, 16, 18, 30, 0, 0, time.UTC)},
+		{"#AT0900", time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)},
+		{"#at1200", time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)},
+		{"#at1159", time.Date(2026, 10, 17, 11, 59, 0, 0, time.UTC)},
+		{"#at2460", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			post := &tgbotapi.Message{Text: tt.text, Entities: []tgbotapi.MessageEntity{{Type: "hashtag", Offset: 0, Length: len(tt.text)}}}
+			got, ok := scheduledTime([]*tgbotapi.Message{post}, now)
+			if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+				t.Errorf("scheduledTime() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliverPost(t *testing.T) {
+	var forwarded []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		forwarded = append(forwarded, form.Get("message_id"))
+		return true, `{"message_id":2,"date":0,"chat":{"id":1,"type":"group"}}`
+	})
+
+	// Quiet for the whole day but a minute, so that posts are held until a
+	// minute from now.
+	now := time.Now().UTC()
+	end := now.Add(time.Minute)
+	escarbot := &EscarBot{
+		Bot:        api,
+		Cache:      NewCache(""),
+		ChannelID:  -100,
+		GroupID:    100,
+		QuietHours: now.Add(2*time.Minute).Format("15:04") + "-" + end.Format("15:04"),
+		Timezone:   "UTC",
+	}
+
+	post := func(id int) []*tgbotapi.Message {
+		return []*tgbotapi.Message{{MessageID: id, Chat: tgbotapi.Chat{ID: -100}, Text: "hello"}}
+	}
+	deliverPost(escarbot, post(1))
+	deliverPost(escarbot, post(2))
+	if queued := escarbot.Cache.GetQueuedPosts(); len(forwarded) != 0 || len(queued) != 2 || queued[0].MessageID() != 1 {
+		t.Fatalf("during quiet hours: forwarded %v, queued %+v", forwarded, queued)
+	}
+
+	updateQueuedPost(escarbot, &tgbotapi.Message{MessageID: 2, Chat: tgbotapi.Chat{ID: -100}, Text: "edited"})
+	if queued, _ := escarbot.Cache.GetQueuedPost(-100, 2); queued.Messages[0].Text != "edited" {
+		t.Errorf("queued post after edit = %q", queued.Messages[0].Text)
+	}
+
+	releasePosts(escarbot, end.Add(time.Second))
+	if !slices.Equal(forwarded, []string{"1", "2"}) || len(escarbot.Cache.GetQueuedPosts()) != 0 {
+		t.Errorf("after quiet hours: forwarded %v, queued %+v", forwarded, escarbot.Cache.GetQueuedPosts())
+	}
+
+	escarbot.QuietHours = ""
+	deliverPost(escarbot, post(3))
+	if !slices.Equal(forwarded, []string{"1", "2", "3"}) {
+		t.Errorf("without quiet hours: forwarded %v", forwarded)
+	}
+
+	// A post asking for the current minute goes out right away.
+	at := time.Now().UTC()
+	tag := "#at" + at.Format("1504")
+	deliverPost(escarbot, []*tgbotapi.Message{{MessageID: 4, Chat: tgbotapi.Chat{ID: -100}, Text: tag, Entities: []tgbotapi.MessageEntity{{Type: "hashtag", Offset: 0, Length: len(tag)}}}})
+	if time.Now().UTC().Minute() == at.Minute() && !slices.Equal(forwarded, []string{"1", "2", "3", "4"}) {
+		t.Errorf("%s in the same minute: forwarded %v, queued %+v", tag, forwarded, escarbot.Cache.GetQueuedPosts())
+	}
+}
@@ -8,35 +8,105 @@ import (
 
 func channelPostHandler(escarbot *EscarBot, message *tgbotapi.Message) {
 	chatId := message.Chat.ID
-	escarbot.StateMutex.RLock()
-	targetChannelID := escarbot.ChannelID
-	targetGroupID := escarbot.GroupID
-	escarbot.StateMutex.RUnlock()
+	channel, managed := GetChannelSettings(escarbot, chatId)
+	if !managed {
+		log.Println("Ignoring message since it did not come from a managed channel.")
+		return
+	}
+	if !channel.Forward {
+		return
+	}
+	if message.MediaGroupID != "" && escarbot.mediaGroups != nil {
+		escarbot.mediaGroups.add(message)
+		return
+	}
+	deliverPost(escarbot, []*tgbotapi.Message{message})
+}
 
-	if chatId != targetChannelID {
-		log.Println("Ignoring message since it did not come from the correct chat_id.")
+// PostCopy is a message sent by a route for a channel post.
+type PostCopy struct {
+	Route     Route `json:"route"`
+	MessageID int   `json:"message_id"`
+}
+
+// Policies for the copies of a deleted channel post.
+const (
+	PostDeleteKeep   = "keep"   // leave the copies in the groups
+	PostDeleteDelete = "delete" // delete the copies too
+)
+
+// PostDeletePolicies are the valid values of PostDeletePolicy.
+var PostDeletePolicies = []string{PostDeleteKeep, PostDeleteDelete}
+
+// channelPostEditHandler brings the copies of an edited channel post up to
+// date: copies are edited in place, forwards are forwarded again and the old
+// ones deleted. Forwards of routes the edited post no longer matches are left
+// alone.
+func channelPostEditHandler(escarbot *EscarBot, message *tgbotapi.Message) {
+	if updateQueuedPost(escarbot, message) {
 		return
 	}
+	copies, ok := escarbot.Cache.GetPostCopies(message.Chat.ID, message.MessageID)
+	if !ok {
+		return
+	}
+
+	for i, c := range copies {
+		if message.MediaGroupID != "" && !c.Route.Copy {
+			// Forwarding an album item again would take it out of the album.
+			continue
+		}
+		if c.Route.Copy {
+			if err := editCopy(escarbot, c.Route, c.MessageID, message); err != nil {
+				log.Printf("Error editing copy %d of post %d from %d: %v", c.MessageID, message.MessageID, message.Chat.ID, err)
+			}
+			continue
+		}
 
-	msg := tgbotapi.NewForward(targetGroupID, chatId, message.MessageID)
-	_, err := escarbot.Bot.Send(msg)
-	if err != nil {
-		log.Println("Error forwarding message to group:", err)
+		if !c.Route.Matches(message) {
+			continue
+		}
+		// The old forward is only deleted once the new one is there.
+		msgID, err := sendRoute(escarbot, c.Route, message)
+		if err != nil {
+			log.Printf("Error forwarding edited post %d from %d to %d: %v", message.MessageID, message.Chat.ID, c.Route.Target, err)
+			continue
+		}
+		deleteMessages(escarbot, c.Route.Target, c.MessageID)
+		escarbot.Cache.DeleteMessage(c.Route.Target, c.MessageID)
+		// Keep the new forward pinned if the old one was.
+		replacePin(escarbot, c.Route.Target, c.MessageID, msgID)
+		copies[i].MessageID = msgID
 	}
+	escarbot.Cache.SetPostCopies(message.Chat.ID, message.MessageID, copies)
 }
 
-func forwardToAdmin(escarbot *EscarBot, message *tgbotapi.Message) {
-	if !message.Chat.IsPrivate() {
-		return
+// DeletePost deletes message msgID of chatID and, if it is a channel post,
+// handles its copies according to PostDeletePolicy. The Bot API does not
+// report deleted messages, so channel posts must be deleted through here for
+// their copies to follow.
+func DeletePost(escarbot *EscarBot, chatID int64, msgID int) error {
+	if _, err := escarbot.Bot.Request(tgbotapi.NewDeleteMessage(chatID, msgID)); err != nil {
+		return err
 	}
+	escarbot.Cache.DeleteMessage(chatID, msgID)
+	escarbot.Cache.DeleteQueuedPost(chatID, msgID)
 
+	copies, ok := escarbot.Cache.GetPostCopies(chatID, msgID)
+	if !ok {
+		return nil
+	}
 	escarbot.StateMutex.RLock()
-	adminID := escarbot.AdminID
+	policy := escarbot.PostDeletePolicy
 	escarbot.StateMutex.RUnlock()
 
-	msg := tgbotapi.NewForward(adminID, message.Chat.ID, message.MessageID)
-	_, err := escarbot.Bot.Send(msg)
-	if err != nil {
-		log.Println("Error forwarding message to admin:", err)
+	if policy == PostDeleteDelete {
+		for _, c := range copies {
+			deleteMessages(escarbot, c.Route.Target, c.MessageID)
+			escarbot.Cache.DeleteMessage(c.Route.Target, c.MessageID)
+			replacePin(escarbot, c.Route.Target, c.MessageID, 0)
+		}
 	}
+	escarbot.Cache.DeletePostCopies(chatID, msgID)
+	return nil
 }
@@ -0,0 +1,96 @@
+package telegram
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestChannelPostEdits(t *testing.T) {
+	var sent []string
+	nextID := 10
+	forwardFails := false
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, method+" "+form.Get("chat_id")+" "+form.Get("message_id"))
+		if method == "forwardMessage" && forwardFails {
+			return false, `"Bad Request: message to forward not found"`
+		}
+		switch method {
+		case "copyMessage":
+			nextID++
+			return true, `{"message_id":` + strconv.Itoa(nextID) + `}`
+		case "forwardMessage":
+			nextID++
+			return true, `{"message_id":` + strconv.Itoa(nextID) + `,"date":0,"chat":{"id":1,"type":"group"}}`
+		case "deleteMessage":
+			return true, `true`
+		}
+		return true, `{"message_id":1,"date":0,"chat":{"id":1,"type":"group"}}`
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache(""), ChannelID: -100, GroupID: 100, ChannelForward: true}
+	SetRoute(escarbot, Route{Source: -100, Target: 200})
+	SetRoute(escarbot, Route{Source: -100, Target: 300, Copy: true})
+	post := &tgbotapi.Message{MessageID: 1, Chat: tgbotapi.Chat{ID: -100}, Text: "hello"}
+
+	channelPostHandler(escarbot, post)
+	if copies, _ := escarbot.Cache.GetPostCopies(-100, 1); len(copies) != 2 || copies[0].MessageID != 11 || copies[1].MessageID != 12 {
+		t.Fatalf("post copies = %+v", copies)
+	}
+
+	sent = nil
+	post.Text = "hello again"
+	channelPostEditHandler(escarbot, post)
+	want := []string{"forwardMessage 200 1", "deleteMessage 200 11", "editMessageText 300 12"}
+	if !slices.Equal(sent, want) {
+		t.Errorf("edit: sent %q, want %q", sent, want)
+	}
+	if copies, _ := escarbot.Cache.GetPostCopies(-100, 1); copies[0].MessageID != 13 {
+		t.Errorf("re-forwarded copy ID = %d, want 13", copies[0].MessageID)
+	}
+
+	// A forward that cannot be sent again leaves the old one in place.
+	sent, forwardFails = nil, true
+	channelPostEditHandler(escarbot, post)
+	forwardFails = false
+	want = []string{"forwardMessage 200 1", "editMessageText 300 12"}
+	if !slices.Equal(sent, want) {
+		t.Errorf("failed edit: sent %q, want %q", sent, want)
+	}
+	if copies, _ := escarbot.Cache.GetPostCopies(-100, 1); copies[0].MessageID != 13 {
+		t.Errorf("copy ID after a failed forward = %d, want 13", copies[0].MessageID)
+	}
+
+	// Routes the edited post no longer matches are not forwarded to again.
+	escarbot.Cache.SetPostCopies(-100, 1, []PostCopy{{Route: Route{Target: 200, Pattern: "hello"}, MessageID: 13}})
+	sent = nil
+	post.Text = "bye"
+	channelPostEditHandler(escarbot, post)
+	if len(sent) != 0 {
+		t.Errorf("edit of a post the route no longer matches: sent %q", sent)
+	}
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{PostDeleteKeep, []string{"deleteMessage -100 1"}},
+		{PostDeleteDelete, []string{"deleteMessage -100 1", "deleteMessage 200 13", "deleteMessage 300 12"}},
+	}
+	for _, tt := range tests {
+		escarbot.PostDeletePolicy = tt.policy
+		escarbot.Cache.SetPostCopies(-100, 1, []PostCopy{{Route: Route{Target: 200}, MessageID: 13}, {Route: Route{Target: 300, Copy: true}, MessageID: 12}})
+		sent = nil
+		if err := DeletePost(escarbot, -100, 1); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(sent, tt.want) {
+			t.Errorf("%s: sent %q, want %q", tt.policy, sent, tt.want)
+		}
+		if _, ok := escarbot.Cache.GetPostCopies(-100, 1); ok {
+			t.Errorf("%s: post copies were kept", tt.policy)
+		}
+	}
+}
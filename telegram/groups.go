@@ -0,0 +1,229 @@
+package telegram
+
+import (
+	"slices"
+)
+
+// GroupSettings holds the settings of a single managed group. The main group
+// (GroupID) keeps them in the top-level EscarBot fields, every other managed
+// group in EscarBot.Groups.
+type GroupSettings struct {
+	LinkDetection     bool            `json:"link_detection"`
+	LinkMode          string          `json:"link_mode"`
+	AutoBan           bool            `json:"auto_ban"`
+	Captcha           bool            `json:"captcha"`
+	CaptchaTimeout    int             `json:"captcha_timeout"`
+	CaptchaMaxRetries int             `json:"captcha_max_retries"`
+	CaptchaText       string          `json:"captcha_text"`
+	WelcomeMessage    bool            `json:"welcome_message"`
+	WelcomeText       string          `json:"welcome_text"`
+	WelcomeLinks      string          `json:"welcome_links"`
+	WelcomePhoto      string          `json:"welcome_photo"`
+	RulesText         string          `json:"rules_text"`
+	BannedWords       []string        `json:"banned_words"`
+	EnabledReplacers  map[string]bool `json:"enabled_replacers"`
+}
+
+// ReplacerEnabled reports whether g uses the replacer called name. Replacers
+// the group never toggled are on.
+func (g GroupSettings) ReplacerEnabled(name string) bool {
+	enabled, toggled := g.EnabledReplacers[name]
+	return !toggled || enabled
+}
+
+// ChannelSettings holds the settings of a managed channel. Where its posts go
+// is decided by the routes whose Source is the channel.
+type ChannelSettings struct {
+	Forward bool `json:"forward"`
+}
+
+// clone returns a copy of g that shares no collections with it.
+func (g GroupSettings) clone() GroupSettings {
+	enabledReplacers := make(map[string]bool, len(g.EnabledReplacers))
+	for name, enabled := range g.EnabledReplacers {
+		enabledReplacers[name] = enabled
+	}
+	g.EnabledReplacers = enabledReplacers
+	g.BannedWords = append([]string(nil), g.BannedWords...)
+	return g
+}
+
+// primaryGroup returns the settings of the main group. The caller must hold
+// StateMutex.
+func primaryGroup(escarbot *EscarBot) GroupSettings {
+	return GroupSettings{
+		LinkDetection:     escarbot.LinkDetection,
+		LinkMode:          escarbot.LinkMode,
+		AutoBan:           escarbot.AutoBan,
+		Captcha:           escarbot.Captcha,
+		CaptchaTimeout:    escarbot.CaptchaTimeout,
+		CaptchaMaxRetries: escarbot.CaptchaMaxRetries,
+		CaptchaText:       escarbot.CaptchaText,
+		WelcomeMessage:    escarbot.WelcomeMessage,
+		WelcomeText:       escarbot.WelcomeText,
+		WelcomeLinks:      escarbot.WelcomeLinks,
+		WelcomePhoto:      escarbot.WelcomePhoto,
+		RulesText:         escarbot.RulesText,
+		BannedWords:       escarbot.BannedWords,
+		EnabledReplacers:  escarbot.EnabledReplacers,
+	}.clone()
+}
+
+// setPrimaryGroup copies g into the main group fields. The caller must hold
+// StateMutex.
+func setPrimaryGroup(escarbot *EscarBot, g GroupSettings) {
+	g = g.clone()
+	escarbot.LinkDetection = g.LinkDetection
+	escarbot.LinkMode = g.LinkMode
+	escarbot.AutoBan = g.AutoBan
+	escarbot.Captcha = g.Captcha
+	escarbot.CaptchaTimeout = g.CaptchaTimeout
+	escarbot.CaptchaMaxRetries = g.CaptchaMaxRetries
+	escarbot.CaptchaText = g.CaptchaText
+	escarbot.WelcomeMessage = g.WelcomeMessage
+	escarbot.WelcomeText = g.WelcomeText
+	escarbot.WelcomeLinks = g.WelcomeLinks
+	escarbot.WelcomePhoto = g.WelcomePhoto
+	escarbot.RulesText = g.RulesText
+	escarbot.BannedWords = g.BannedWords
+	escarbot.EnabledReplacers = g.EnabledReplacers
+}
+
+// GetGroupSettings returns the settings of the group chatID and whether it is
+// managed. Unmanaged chats get the main group's settings.
+func GetGroupSettings(escarbot *EscarBot, chatID int64) (GroupSettings, bool) {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	if chatID != escarbot.GroupID {
+		if group, ok := escarbot.Groups[chatID]; ok {
+			return group.clone(), true
+		}
+		return primaryGroup(escarbot), false
+	}
+	return primaryGroup(escarbot), true
+}
+
+// UpdateGroupSettings calls update on the settings of the managed group
+// chatID and stores the result. It returns the settings before and after the
+// update, and false if the group is not managed.
+func UpdateGroupSettings(escarbot *EscarBot, chatID int64, update func(*GroupSettings)) (old, next GroupSettings, ok bool) {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+
+	if chatID == escarbot.GroupID {
+		old = primaryGroup(escarbot)
+	} else if group, managed := escarbot.Groups[chatID]; managed {
+		old = group.clone()
+	} else {
+		return GroupSettings{}, GroupSettings{}, false
+	}
+	next = old.clone()
+	update(&next)
+
+	if chatID == escarbot.GroupID {
+		setPrimaryGroup(escarbot, next)
+	} else {
+		escarbot.Groups[chatID] = next.clone()
+	}
+	return old, next, true
+}
+
+// GetChannelSettings returns the settings of the channel chatID and whether
+// it is managed.
+func GetChannelSettings(escarbot *EscarBot, chatID int64) (ChannelSettings, bool) {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	if chatID == escarbot.ChannelID {
+		return ChannelSettings{Forward: escarbot.ChannelForward}, true
+	}
+	channel, ok := escarbot.Channels[chatID]
+	return channel, ok
+}
+
+// UpdateChannelSettings works like UpdateGroupSettings for the managed
+// channels other than the main one.
+func UpdateChannelSettings(escarbot *EscarBot, chatID int64, update func(*ChannelSettings)) (old, next ChannelSettings, ok bool) {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+
+	old, ok = escarbot.Channels[chatID]
+	if !ok || chatID == escarbot.ChannelID {
+		return ChannelSettings{}, ChannelSettings{}, false
+	}
+	next = old
+	update(&next)
+	escarbot.Channels[chatID] = next
+	return old, next, true
+}
+
+// SetManagedGroup starts or stops managing the group chatID. New groups start
+// with a copy of the main group's settings. It reports whether anything
+// changed; the main group is always managed.
+func SetManagedGroup(escarbot *EscarBot, chatID int64, managed bool) bool {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+
+	_, exists := escarbot.Groups[chatID]
+	if chatID == 0 || chatID == escarbot.GroupID || exists == managed {
+		return false
+	}
+	if !managed {
+		delete(escarbot.Groups, chatID)
+		return true
+	}
+	if escarbot.Groups == nil {
+		escarbot.Groups = make(map[int64]GroupSettings)
+	}
+	escarbot.Groups[chatID] = primaryGroup(escarbot)
+	return true
+}
+
+// SetManagedChannel starts or stops managing the channel chatID. Routes from
+// a channel are dropped with it. It reports whether anything changed; the main
+// channel is always managed.
+func SetManagedChannel(escarbot *EscarBot, chatID int64, managed bool) bool {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+
+	_, exists := escarbot.Channels[chatID]
+	if chatID == 0 || chatID == escarbot.ChannelID || exists == managed {
+		return false
+	}
+	if !managed {
+		delete(escarbot.Channels, chatID)
+		escarbot.Routes = slices.DeleteFunc(escarbot.Routes, func(r Route) bool { return r.Source == chatID })
+		compileRoutes(escarbot.Routes)
+		return true
+	}
+	if escarbot.Channels == nil {
+		escarbot.Channels = make(map[int64]ChannelSettings)
+	}
+	escarbot.Channels[chatID] = ChannelSettings{Forward: true}
+	return true
+}
+
+// ManagedGroups returns the IDs of the managed groups, main group first.
+func ManagedGroups(escarbot *EscarBot) []int64 {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	return append([]int64{escarbot.GroupID}, sortedIDs(escarbot.Groups)...)
+}
+
+// ManagedChannels returns the IDs of the managed channels, main channel first.
+func ManagedChannels(escarbot *EscarBot) []int64 {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	return append([]int64{escarbot.ChannelID}, sortedIDs(escarbot.Channels)...)
+}
+
+// sortedIDs returns the keys of the given maps, sorted and without duplicates.
+func sortedIDs[V any](maps ...map[int64]V) []int64 {
+	var ids []int64
+	for _, m := range maps {
+		for id := range m {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestGroupSettings(t *testing.T) {
+	escarbot := &EscarBot{
+		GroupID:          100,
+		ChannelID:        -100,
+		AutoBan:          true,
+		CaptchaTimeout:   120,
+		EnabledReplacers: map[string]bool{"Twitter": true},
+	}
+
+	if _, managed := GetGroupSettings(escarbot, 200); managed {
+		t.Fatalf("GetGroupSettings() reports unmanaged group 200 as managed")
+	}
+	if !SetManagedGroup(escarbot, 200, true) || SetManagedGroup(escarbot, 200, true) || SetManagedGroup(escarbot, 100, false) {
+		t.Fatalf("SetManagedGroup() reported the wrong changes")
+	}
+
+	group, managed := GetGroupSettings(escarbot, 200)
+	if !managed || !group.AutoBan || group.CaptchaTimeout != 120 {
+		t.Fatalf("new group = %+v, %v, want a copy of the main group", group, managed)
+	}
+
+	old, next, ok := UpdateGroupSettings(escarbot, 200, func(g *GroupSettings) {
+		g.AutoBan = false
+		g.EnabledReplacers["Twitter"] = false
+	})
+	if !ok || !old.AutoBan || next.AutoBan {
+		t.Errorf("UpdateGroupSettings() = %+v, %+v, %v", old, next, ok)
+	}
+	if !escarbot.AutoBan || !escarbot.EnabledReplacers["Twitter"] {
+		t.Errorf("updating group 200 changed the main group")
+	}
+
+	if _, _, ok := UpdateGroupSettings(escarbot, 100, func(g *GroupSettings) { g.CaptchaTimeout = 60 }); !ok || escarbot.CaptchaTimeout != 60 {
+		t.Errorf("UpdateGroupSettings() of the main group: ok = %v, CaptchaTimeout = %d", ok, escarbot.CaptchaTimeout)
+	}
+	if _, _, ok := UpdateGroupSettings(escarbot, 300, func(g *GroupSettings) {}); ok {
+		t.Errorf("UpdateGroupSettings() of an unmanaged group succeeded")
+	}
+
+	if got := ManagedGroups(escarbot); !slices.Equal(got, []int64{100, 200}) {
+		t.Errorf("ManagedGroups() = %v", got)
+	}
+	if !SetManagedGroup(escarbot, 200, false) {
+		t.Errorf("SetManagedGroup() did not remove group 200")
+	}
+}
+
+func TestChannelSettings(t *testing.T) {
+	escarbot := &EscarBot{GroupID: 100, ChannelID: -100, ChannelForward: true}
+
+	if channel, managed := GetChannelSettings(escarbot, -100); !managed || !channel.Forward {
+		t.Errorf("main channel = %+v, %v", channel, managed)
+	}
+	if _, _, ok := UpdateChannelSettings(escarbot, -100, func(c *ChannelSettings) {}); ok {
+		t.Errorf("UpdateChannelSettings() of the main channel succeeded")
+	}
+
+	SetManagedChannel(escarbot, -200, true)
+	UpdateChannelSettings(escarbot, -200, func(c *ChannelSettings) { c.Forward = false })
+	if channel, managed := GetChannelSettings(escarbot, -200); !managed || channel.Forward {
+		t.Errorf("channel -200 = %+v, %v", channel, managed)
+	}
+
+	SetRoute(escarbot, Route{Source: -200, Target: 100})
+	SetManagedChannel(escarbot, -200, false)
+	if routes := GetRoutes(escarbot, 0); len(routes) != 0 {
+		t.Errorf("routes of a removed channel were kept: %+v", routes)
+	}
+}
+
+func TestProcessJoinManagedGroup(t *testing.T) {
+	bot := &EscarBot{
+		Cache:   NewCache(""),
+		GroupID: 100,
+		Groups:  map[int64]GroupSettings{200: {}}, // no autoban, captcha or welcome
+	}
+
+	processJoin(bot, 200, tgbotapi.User{ID: 123, FirstName: "TestUser"}, 1)
+	if _, exists := bot.Cache.GetJoinEntry(200, 123); !exists {
+		t.Errorf("processJoin() ignored a join in a managed group")
+	}
+
+	// Joining a second group right after is not a duplicate of the first join.
+	processJoin(bot, 100, tgbotapi.User{ID: 123, FirstName: "TestUser"}, 2)
+	if entry, exists := bot.Cache.GetJoinEntry(100, 123); !exists || entry.JoinMsgID != 2 {
+		t.Errorf("processJoin() skipped a join in another group: %+v, %v", entry, exists)
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	s := Settings{
+		GroupID:        100,
+		CaptchaTimeout: 120,
+		Groups: map[int64]GroupSettings{
+			100: {CaptchaTimeout: 120},
+			200: {CaptchaTimeout: 0},
+		},
+		Routes: []Route{{ID: "1", Source: -200, Target: 100}},
+	}
+	err := s.Validate()
+	if err == nil {
+		t.Fatalf("Validate() = nil")
+	}
+	for _, want := range []string{"invalid group ID 100", "groups.200.captcha_timeout", "channel -200 is not managed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestDiffSettingsGroups(t *testing.T) {
+	old := Settings{Groups: map[int64]GroupSettings{200: {AutoBan: true}}}
+	next := Settings{
+		Groups:   map[int64]GroupSettings{200: {AutoBan: false}},
+		Channels: map[int64]ChannelSettings{-200: {Forward: true}},
+	}
+
+	changes := DiffSettings(old, next)
+	want := []SettingChange{
+		{"Group 200 AutoBan", "true", "false"},
+		{"Channel -200", "false", "true"},
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("DiffSettings() = %+v, want %+v", changes, want)
+	}
+}
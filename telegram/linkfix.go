@@ -0,0 +1,213 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Ways link detection shows the fixed links of a message.
+const (
+	LinkModeReply   = "reply"   // answer the message with the fixed links
+	LinkModeReplace = "replace" // delete the message and repost it with the fixed links
+)
+
+// LinkModes are the valid values of LinkMode.
+var LinkModes = []string{LinkModeReply, LinkModeReplace}
+
+// Callback data of the buttons under the messages of link detection.
+const (
+	linkUndoData     = "linkundo" // undo button of reposts
+	linkDeletePrefix = "linkdel:" // delete button of replies, followed by the author ID
+)
+
+// LinkFix is a message sent by link detection for a message of AuthorID.
+type LinkFix struct {
+	AuthorID int64  `json:"author_id"`
+	Original string `json:"original,omitempty"` // reposts only: HTML text or caption restored by undo
+	Media    bool   `json:"media,omitempty"`    // Original is a caption
+}
+
+// userMentionHTML returns an HTML mention of user.
+func userMentionHTML(user tgbotapi.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if user.UserName != "" {
+		name = "@" + user.UserName
+	}
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, user.ID, html.EscapeString(name))
+}
+
+// linksHTML renders links like linkReply, without the credit.
+func linksHTML(links []string) string {
+	if len(links) == 1 {
+		return `<a href="` + html.EscapeString(links[0]) + `">🔗</a>`
+	}
+	numbered := make([]string, len(links))
+	for i, link := range links {
+		numbered[i] = fmt.Sprintf(`<a href="%s">🔗%d</a>`, html.EscapeString(link), i+1)
+	}
+	return strings.Join(numbered, " ")
+}
+
+// repostLinks deletes message and sends it again, credited to its author, with
+// the fixed links and a button that lets the author undo the change. It
+// reports whether it did: messages that cannot be reposted as they are, like
+// album items, are left alone, and the repost is withdrawn if the original
+// cannot be deleted.
+func repostLinks(escarbot *EscarBot, message *tgbotapi.Message, links []string) bool {
+	if message.From == nil || message.MediaGroupID != "" {
+		return false
+	}
+	mediaType := messageMediaType(message)
+	media := mediaType != "text"
+	if media && !slices.Contains(captionMediaTypes, mediaType) {
+		return false
+	}
+
+	text, entities := message.Text, message.Entities
+	if media {
+		text, entities = message.Caption, message.CaptionEntities
+	}
+	original := userMentionHTML(*message.From) + ":\n" + entitiesToHTML(text, entities)
+	fixed := original + "\n\n" + linksHTML(links)
+	undo := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("↩️ Undo", linkUndoData),
+	))
+
+	chatID := message.Chat.ID
+	reply := tgbotapi.ReplyParameters{AllowSendingWithoutReply: true}
+	// Messages in forum topics reply to the topic's first message when they
+	// reply to nothing else.
+	if r := message.ReplyToMessage; r != nil && r.MessageID != message.MessageThreadID {
+		reply.MessageID = r.MessageID
+	}
+
+	var sentID int
+	var err error
+	if media {
+		msg := tgbotapi.NewCopyMessage(chatID, chatID, message.MessageID)
+		msg.MessageThreadID = message.MessageThreadID
+		msg.ReplyParameters = reply
+		msg.Caption = fixed
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.ReplyMarkup = &undo
+		var sent tgbotapi.MessageID
+		sent, err = escarbot.Bot.CopyMessage(msg)
+		sentID = sent.MessageID
+	} else {
+		msg := tgbotapi.NewMessage(chatID, fixed)
+		msg.MessageThreadID = message.MessageThreadID
+		msg.ReplyParameters = reply
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.LinkPreviewOptions.URL = links[0]
+		msg.ReplyMarkup = undo
+		var sent tgbotapi.Message
+		sent, err = escarbot.Bot.Send(msg)
+		sentID = sent.MessageID
+	}
+	if err != nil {
+		log.Printf("Error reposting message %d in %d with fixed links: %v", message.MessageID, chatID, err)
+		return false
+	}
+
+	if _, err := escarbot.Bot.Request(tgbotapi.NewDeleteMessage(chatID, message.MessageID)); err != nil {
+		log.Printf("Error deleting message %d in %d after reposting it: %v", message.MessageID, chatID, err)
+		deleteMessages(escarbot, chatID, sentID)
+		return false
+	}
+	escarbot.Cache.DeleteMessage(chatID, message.MessageID)
+	escarbot.Cache.SetLinkFix(chatID, sentID, LinkFix{AuthorID: message.From.ID, Original: original, Media: media})
+	return true
+}
+
+// handleLinkUndo restores the original text or caption of a repost when its
+// author presses the undo button.
+func handleLinkUndo(escarbot *EscarBot, callback *tgbotapi.CallbackQuery) {
+	if callback.Message == nil {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	chatID, msgID := callback.Message.Chat.ID, callback.Message.MessageID
+
+	fix, ok := escarbot.Cache.GetLinkFix(chatID, msgID)
+	if !ok || fix.Original == "" {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, "This message can no longer be restored."))
+		return
+	}
+	if callback.From.ID != fix.AuthorID {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, "Only the author can undo this."))
+		return
+	}
+
+	// Edits without a keyboard remove the undo button.
+	var edit tgbotapi.Chattable
+	if fix.Media {
+		msg := tgbotapi.NewEditMessageCaption(chatID, msgID, fix.Original)
+		msg.ParseMode = tgbotapi.ModeHTML
+		edit = msg
+	} else {
+		msg := tgbotapi.NewEditMessageText(chatID, msgID, fix.Original)
+		msg.ParseMode = tgbotapi.ModeHTML
+		edit = msg
+	}
+	if _, err := escarbot.Bot.Request(edit); err != nil {
+		log.Printf("Error restoring repost %d in %d: %v", msgID, chatID, err)
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	escarbot.Cache.DeleteLinkFix(chatID, msgID)
+	escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, "Restored."))
+}
+
+// linkDeleteButton returns the keyboard letting authorID, or an admin, delete
+// a reply with fixed links.
+func linkDeleteButton(authorID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑", linkDeletePrefix+strconv.FormatInt(authorID, 10)),
+	))
+}
+
+// isChatAdmin reports whether userID is an administrator of chatID.
+func isChatAdmin(escarbot *EscarBot, chatID, userID int64) bool {
+	member, err := escarbot.Bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}, UserID: userID},
+	})
+	if err != nil {
+		log.Printf("Error getting member %d of chat %d: %v", userID, chatID, err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// handleLinkDelete deletes a reply with fixed links when its delete button is
+// pressed by the author of the original message or by an admin.
+func handleLinkDelete(escarbot *EscarBot, callback *tgbotapi.CallbackQuery) {
+	if callback.Message == nil {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	chatID, msgID := callback.Message.Chat.ID, callback.Message.MessageID
+
+	authorID, err := strconv.ParseInt(strings.TrimPrefix(callback.Data, linkDeletePrefix), 10, 64)
+	if err != nil {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	if callback.From.ID != authorID && !isChatAdmin(escarbot, chatID, callback.From.ID) {
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, "Only the author or an admin can delete this."))
+		return
+	}
+
+	if _, err := escarbot.Bot.Request(tgbotapi.NewDeleteMessage(chatID, msgID)); err != nil {
+		log.Printf("Error deleting link reply %d in %d: %v", msgID, chatID, err)
+		escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	escarbot.Cache.DeleteMessage(chatID, msgID)
+	escarbot.Bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+}
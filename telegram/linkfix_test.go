@@ -0,0 +1,202 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestRepostLinks(t *testing.T) {
+	var calls []string
+	var sent []url.Values
+	deleteFails := false
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		calls = append(calls, method)
+		sent = append(sent, form)
+		switch method {
+		case "deleteMessage":
+			if deleteFails {
+				return false, `"Bad Request: message can't be deleted"`
+			}
+			return true, "true"
+		case "sendMessage":
+			return true, `{"message_id":50,"date":0,"chat":{"id":-100,"type":"supergroup"}}`
+		case "copyMessage":
+			return true, `{"message_id":51}`
+		}
+		return true, "true"
+	})
+	escarbot := &EscarBot{
+		Bot:              api,
+		Cache:            NewCache(""),
+		GroupID:          -100,
+		LinkDetection:    true,
+		LinkMode:         LinkModeReplace,
+		Replacers:        DefaultReplacers(),
+		EnabledReplacers: map[string]bool{},
+	}
+	author := &tgbotapi.User{ID: 7, FirstName: "Ness"}
+	chat := tgbotapi.Chat{ID: -100, Type: "supergroup"}
+	tweet := "https://twitter.com/a/status/1"
+
+	tests := []struct {
+		name        string
+		message     *tgbotapi.Message
+		deleteFails bool
+		wantCalls   string
+		wantText    string
+	}{
+		{
+			name:      "text",
+			message:   &tgbotapi.Message{MessageID: 10, From: author, Chat: chat, MessageThreadID: 3, Text: "look " + tweet, Entities: []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 4}, {Type: "url", Offset: 5, Length: len(tweet)}}},
+			wantCalls: "sendMessage deleteMessage",
+			wantText:  `<a href="tg://user?id=7">Ness</a>:` + "\n<b>look</b> " + tweet + "\n\n" + `<a href="https://fxtwitter.com/a/status/1">🔗</a>`,
+		},
+		{
+			name:      "caption",
+			message:   &tgbotapi.Message{MessageID: 11, From: author, Chat: chat, Photo: []tgbotapi.PhotoSize{{FileID: "p"}}, Caption: tweet, CaptionEntities: []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(tweet)}}},
+			wantCalls: "copyMessage deleteMessage",
+		},
+		{
+			name:      "album item",
+			message:   &tgbotapi.Message{MessageID: 12, From: author, Chat: chat, MediaGroupID: "g", Photo: []tgbotapi.PhotoSize{{FileID: "p"}}, Caption: tweet, CaptionEntities: []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(tweet)}}},
+			wantCalls: "sendMessage",
+			wantText:  "[🔗](https://fxtwitter.com/a/status/1) Da [Ness](tg://user?id=7).",
+		},
+		{
+			name:      "private chat",
+			message:   &tgbotapi.Message{MessageID: 14, From: author, Chat: tgbotapi.Chat{ID: 7, Type: "private"}, Text: tweet, Entities: []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(tweet)}}},
+			wantCalls: "sendMessage",
+			wantText:  "[🔗](https://fxtwitter.com/a/status/1) Da [Ness](tg://user?id=7).",
+		},
+		{
+			name:        "original not deletable",
+			message:     &tgbotapi.Message{MessageID: 13, From: author, Chat: chat, Text: tweet, Entities: []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(tweet)}}},
+			deleteFails: true,
+			wantCalls:   "sendMessage deleteMessage deleteMessage sendMessage",
+			wantText:    "[🔗](https://fxtwitter.com/a/status/1) Da [Ness](tg://user?id=7).",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, sent, deleteFails = nil, nil, tt.deleteFails
+			handleLinks(escarbot, tt.message)
+			if got := strings.Join(calls, " "); got != tt.wantCalls {
+				t.Fatalf("calls = %q, want %q", got, tt.wantCalls)
+			}
+			if tt.wantText == "" {
+				return
+			}
+			var got string
+			for i, method := range calls {
+				if method == "sendMessage" {
+					got = sent[i].Get("text")
+				}
+			}
+			if got != tt.wantText {
+				t.Errorf("text = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+
+	if fix, ok := escarbot.Cache.GetLinkFix(-100, 50); !ok || fix.AuthorID != 7 || fix.Media {
+		t.Errorf("text repost = %+v, %v", fix, ok)
+	}
+	if fix, ok := escarbot.Cache.GetLinkFix(-100, 51); !ok || !fix.Media || fix.Original != `<a href="tg://user?id=7">Ness</a>:`+"\n"+tweet {
+		t.Errorf("caption repost = %+v, %v", fix, ok)
+	}
+}
+
+func TestHandleLinkUndo(t *testing.T) {
+	var calls []string
+	var answers []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		calls = append(calls, method)
+		if method == "answerCallbackQuery" {
+			answers = append(answers, form.Get("text"))
+		}
+		return true, "true"
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache("")}
+	escarbot.Cache.SetLinkFix(-100, 50, LinkFix{AuthorID: 7, Original: "Ness:\nhello"})
+
+	undo := func(userID int64) {
+		calls, answers = nil, nil
+		handleLinkUndo(escarbot, &tgbotapi.CallbackQuery{
+			ID:      "q",
+			From:    &tgbotapi.User{ID: userID},
+			Message: &tgbotapi.Message{MessageID: 50, Chat: tgbotapi.Chat{ID: -100}},
+			Data:    linkUndoData,
+		})
+	}
+
+	undo(8)
+	if strings.Join(calls, " ") != "answerCallbackQuery" || answers[0] != "Only the author can undo this." {
+		t.Errorf("other user: calls = %v, answers = %v", calls, answers)
+	}
+	undo(7)
+	if strings.Join(calls, " ") != "editMessageText answerCallbackQuery" {
+		t.Errorf("author: calls = %v", calls)
+	}
+	if _, ok := escarbot.Cache.GetLinkFix(-100, 50); ok {
+		t.Error("link fix kept after undo")
+	}
+	undo(7)
+	if answers[0] != "This message can no longer be restored." {
+		t.Errorf("second undo: answers = %v", answers)
+	}
+
+	// Buttons of inaccessible messages must still stop loading.
+	calls = nil
+	handleLinkUndo(escarbot, &tgbotapi.CallbackQuery{ID: "q", From: &tgbotapi.User{ID: 7}, Data: linkUndoData})
+	if strings.Join(calls, " ") != "answerCallbackQuery" {
+		t.Errorf("no message: calls = %v", calls)
+	}
+}
+
+func TestHandleLinkDelete(t *testing.T) {
+	var calls []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		calls = append(calls, method)
+		if method == "getChatMember" {
+			status := "member"
+			if form.Get("user_id") == "9" {
+				status = "administrator"
+			}
+			return true, `{"user":{"id":` + form.Get("user_id") + `,"is_bot":false,"first_name":"X"},"status":"` + status + `"}`
+		}
+		return true, "true"
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache("")}
+
+	tests := []struct {
+		name   string
+		userID int64
+		data   string
+		want   string
+	}{
+		{"author", 7, linkDeletePrefix + "7", "deleteMessage answerCallbackQuery"},
+		{"admin", 9, linkDeletePrefix + "7", "getChatMember deleteMessage answerCallbackQuery"},
+		{"someone else", 8, linkDeletePrefix + "7", "getChatMember answerCallbackQuery"},
+		{"bad data", 7, linkDeletePrefix + "x", "answerCallbackQuery"},
+		{"unknown button", 7, "nope", "answerCallbackQuery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			HandleCallback(escarbot, &tgbotapi.CallbackQuery{
+				ID:      "q",
+				From:    &tgbotapi.User{ID: tt.userID},
+				Message: &tgbotapi.Message{MessageID: 50, Chat: tgbotapi.Chat{ID: -100}},
+				Data:    tt.data,
+			})
+			if got := strings.Join(calls, " "); got != tt.want {
+				t.Errorf("calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// Modmail relays private messages to the staff. With ModmailGroupID set, each
+// user gets a ticket: a forum topic of that group where their messages land
+// and where every staff message is copied back to them. Otherwise messages
+// are forwarded to the admin chat (AdminID), and replies to them are copied
+// back. /block and /unblock stop or resume the relay for a user.
+
+// Ticket is the modmail conversation with a user, held in a forum topic of
+// the staff group.
+type Ticket struct {
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name"`
+	ChatID    int64     `json:"chat_id"`
+	ThreadID  int       `json:"thread_id"`
+	Open      bool      `json:"open"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Link returns the t.me link to the ticket topic.
+func (t Ticket) Link() string {
+	return privateLink(t.ChatID, t.ThreadID)
+}
+
+// OpenTickets returns the open modmail tickets, most recently active first.
+func OpenTickets(escarbot *EscarBot) []Ticket {
+	tickets := slices.DeleteFunc(escarbot.Cache.GetTickets(), func(t Ticket) bool { return !t.Open })
+	slices.SortFunc(tickets, func(a, b Ticket) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return tickets
+}
+
+// handleModmail routes message through modmail: staff messages may be
+// replies or commands, private messages from users are relayed.
+func handleModmail(escarbot *EscarBot, message *tgbotapi.Message) {
+	escarbot.StateMutex.RLock()
+	adminID := escarbot.AdminID
+	adminForward := escarbot.AdminForward
+	groupID := escarbot.ModmailGroupID
+	escarbot.StateMutex.RUnlock()
+
+	switch {
+	case groupID != 0 && message.Chat.ID == groupID:
+		handleTicketMessage(escarbot, message)
+	case adminID != 0 && message.Chat.ID == adminID:
+		handleAdminReply(escarbot, message)
+	case !adminForward || !message.Chat.IsPrivate() || message.From == nil:
+	case escarbot.Cache.IsModmailBlocked(message.From.ID):
+	case groupID != 0:
+		forwardToTicket(escarbot, groupID, message)
+	default:
+		forwardToAdmin(escarbot, adminID, message)
+	}
+}
+
+// forwardToAdmin forwards a private message to the admin chat and remembers
+// who sent it, so that the admins can reply.
+func forwardToAdmin(escarbot *EscarBot, adminID int64, message *tgbotapi.Message) {
+	msg := tgbotapi.NewForward(adminID, message.Chat.ID, message.MessageID)
+	forwarded, err := escarbot.Bot.Send(msg)
+	if err != nil {
+		log.Println("Error forwarding message to admin:", err)
+		return
+	}
+	escarbot.Cache.SetModmailUser(adminID, forwarded.MessageID, message.From.ID)
+}
+
+// handleAdminReply copies a reply to a forwarded message back to its sender,
+// or runs the /block and /unblock commands on them.
+func handleAdminReply(escarbot *EscarBot, message *tgbotapi.Message) {
+	if message.ReplyToMessage == nil {
+		return
+	}
+	userID, ok := escarbot.Cache.GetModmailUser(message.Chat.ID, message.ReplyToMessage.MessageID)
+	if !ok {
+		return
+	}
+	if !blockCommand(escarbot, message, userID) {
+		relayToUser(escarbot, message, userID)
+	}
+}
+
+// forwardToTicket forwards a private message to the ticket of its sender,
+// opening one if needed.
+func forwardToTicket(escarbot *EscarBot, groupID int64, message *tgbotapi.Message) {
+	ticket, err := openTicket(escarbot, groupID, message.From)
+	if err != nil {
+		log.Printf("Error opening ticket for user %d: %v", message.From.ID, err)
+		return
+	}
+
+	msg := tgbotapi.NewForward(groupID, message.Chat.ID, message.MessageID)
+	msg.MessageThreadID = ticket.ThreadID
+	if _, err := escarbot.Bot.Send(msg); err != nil {
+		log.Printf("Error forwarding message to ticket of user %d: %v", message.From.ID, err)
+		return
+	}
+	ticket.UpdatedAt = time.Now()
+	escarbot.Cache.SetTicket(ticket)
+}
+
+// openTicket returns the open ticket of user in groupID. Closed tickets are
+// reopened, and a new forum topic is created for users without one.
+func openTicket(escarbot *EscarBot, groupID int64, user *tgbotapi.User) (Ticket, error) {
+	ticket, ok := escarbot.Cache.GetTicket(user.ID)
+	if ok && ticket.ChatID == groupID {
+		if !ticket.Open {
+			if err := setTicketOpen(escarbot, &ticket, true); err != nil {
+				return Ticket{}, err
+			}
+		}
+		return ticket, nil
+	}
+
+	name := user.FirstName
+	if user.UserName != "" {
+		name += " @" + user.UserName
+	}
+	topic, err := escarbot.Bot.CreateForumTopic(tgbotapi.CreateForumTopicConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: groupID},
+		Name:       truncate(fmt.Sprintf("%s [%d]", name, user.ID), 128),
+	})
+	if err != nil {
+		return Ticket{}, err
+	}
+	now := time.Now()
+	ticket = Ticket{
+		UserID:    user.ID,
+		UserName:  name,
+		ChatID:    groupID,
+		ThreadID:  topic.MessageThreadID,
+		Open:      true,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	escarbot.Cache.SetTicket(ticket)
+	return ticket, nil
+}
+
+// setTicketOpen closes or reopens the topic of ticket and stores its state.
+func setTicketOpen(escarbot *EscarBot, ticket *Ticket, open bool) error {
+	forum := tgbotapi.BaseForum{ChatConfig: tgbotapi.ChatConfig{ChatID: ticket.ChatID}, MessageThreadID: ticket.ThreadID}
+	var err error
+	if open {
+		_, err = escarbot.Bot.Request(tgbotapi.ReopenForumTopicConfig{BaseForum: forum})
+	} else {
+		_, err = escarbot.Bot.Request(tgbotapi.CloseForumTopicConfig{BaseForum: forum})
+	}
+	if err != nil {
+		return err
+	}
+	ticket.Open = open
+	ticket.UpdatedAt = time.Now()
+	escarbot.Cache.SetTicket(*ticket)
+	return nil
+}
+
+// handleTicketMessage relays a staff message in a ticket topic to the user,
+// or runs the /close, /reopen, /block and /unblock commands.
+func handleTicketMessage(escarbot *EscarBot, message *tgbotapi.Message) {
+	if !message.IsTopicMessage || message.From == nil || message.From.IsBot ||
+		message.ForumTopicCreated != nil || message.ForumTopicEdited != nil ||
+		message.ForumTopicClosed != nil || message.ForumTopicReopened != nil {
+		return
+	}
+	ticket, ok := escarbot.Cache.GetTicketByThread(message.Chat.ID, message.MessageThreadID)
+	if !ok {
+		return
+	}
+
+	switch command := message.Command(); command {
+	case "close", "reopen":
+		open := command == "reopen"
+		if ticket.Open == open {
+			return
+		}
+		if err := setTicketOpen(escarbot, &ticket, open); err != nil {
+			log.Printf("Error updating ticket of user %d: %v", ticket.UserID, err)
+			replyToStaff(escarbot, message, fmt.Sprintf("⚠️ Could not update the ticket: %v", err))
+		}
+		return
+	}
+	if blockCommand(escarbot, message, ticket.UserID) {
+		return
+	}
+	if !ticket.Open {
+		replyToStaff(escarbot, message, "🔒 This ticket is closed, use /reopen to answer.")
+		return
+	}
+	relayToUser(escarbot, message, ticket.UserID)
+}
+
+// blockCommand runs the /block and /unblock commands of a staff message on
+// userID. It reports whether message was one of them.
+func blockCommand(escarbot *EscarBot, message *tgbotapi.Message, userID int64) bool {
+	switch message.Command() {
+	case "block":
+		escarbot.Cache.SetModmailBlocked(userID, true)
+		replyToStaff(escarbot, message, fmt.Sprintf("🚫 User %d can no longer write to modmail.", userID))
+	case "unblock":
+		escarbot.Cache.SetModmailBlocked(userID, false)
+		replyToStaff(escarbot, message, fmt.Sprintf("✅ User %d can write to modmail again.", userID))
+	default:
+		return false
+	}
+	return true
+}
+
+// relayToUser copies a staff message, text or media, to userID.
+func relayToUser(escarbot *EscarBot, message *tgbotapi.Message, userID int64) {
+	msg := tgbotapi.NewCopyMessage(userID, message.Chat.ID, message.MessageID)
+	if _, err := escarbot.Bot.CopyMessage(msg); err != nil {
+		log.Printf("Error relaying modmail reply to user %d: %v", userID, err)
+		replyToStaff(escarbot, message, fmt.Sprintf("⚠️ Could not deliver the reply: %v", err))
+	}
+}
+
+// replyToStaff answers a staff message in its chat and topic.
+func replyToStaff(escarbot *EscarBot, message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.MessageThreadID = message.MessageThreadID
+	msg.ReplyParameters.MessageID = message.MessageID
+	if _, err := escarbot.Bot.Send(msg); err != nil {
+		log.Printf("Error replying to staff: %v", err)
+	}
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
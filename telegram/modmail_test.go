@@ -0,0 +1,125 @@
+package telegram
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestModmail(t *testing.T) {
+	var sent []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, method+" "+form.Get("chat_id"))
+		if method == "copyMessage" {
+			return true, `{"message_id":3}`
+		}
+		return true, `{"message_id":2,"date":0,"chat":{"id":10,"type":"private"}}`
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache(""), AdminID: 10, AdminForward: true}
+
+	user := &tgbotapi.User{ID: 42, FirstName: "User"}
+	private := &tgbotapi.Message{MessageID: 1, From: user, Chat: tgbotapi.Chat{ID: 42, Type: "private"}, Text: "help"}
+	forwarded := &tgbotapi.Message{MessageID: 2, Chat: tgbotapi.Chat{ID: 10, Type: "private"}}
+	adminReply := func(text string, entities ...tgbotapi.MessageEntity) *tgbotapi.Message {
+		return &tgbotapi.Message{
+			MessageID:      5,
+			From:           &tgbotapi.User{ID: 10},
+			Chat:           tgbotapi.Chat{ID: 10, Type: "private"},
+			Text:           text,
+			Entities:       entities,
+			ReplyToMessage: forwarded,
+		}
+	}
+	command := tgbotapi.MessageEntity{Type: "bot_command", Offset: 0, Length: 6}
+
+	tests := []struct {
+		name    string
+		message *tgbotapi.Message
+		want    string
+	}{
+		{"user message", private, "forwardMessage 10"},
+		{"admin reply", adminReply("hi"), "copyMessage 42"},
+		{"admin message", &tgbotapi.Message{MessageID: 6, Chat: tgbotapi.Chat{ID: 10, Type: "private"}, Text: "note"}, ""},
+		{"block", adminReply("/block", command), "sendMessage 10"},
+		{"blocked user", private, ""},
+		{"unblock", adminReply("/unblock", tgbotapi.MessageEntity{Type: "bot_command", Length: 8}), "sendMessage 10"},
+		{"unblocked user", private, "forwardMessage 10"},
+	}
+
+	for _, tt := range tests {
+		sent = nil
+		handleModmail(escarbot, tt.message)
+		got := ""
+		if len(sent) > 0 {
+			got = sent[0]
+		}
+		if len(sent) > 1 || got != tt.want {
+			t.Errorf("%s: sent %q, want %q", tt.name, sent, tt.want)
+		}
+	}
+}
+
+func TestModmailTickets(t *testing.T) {
+	var sent []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, method+" "+form.Get("chat_id")+" "+form.Get("message_thread_id"))
+		switch method {
+		case "createForumTopic":
+			return true, `{"message_thread_id":7,"name":"User [42]","icon_color":0}`
+		case "copyMessage":
+			return true, `{"message_id":3}`
+		case "closeForumTopic", "reopenForumTopic":
+			return true, `true`
+		}
+		return true, `{"message_id":2,"date":0,"chat":{"id":-100,"type":"supergroup"}}`
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache(""), AdminID: 10, AdminForward: true, ModmailGroupID: -1001234}
+
+	user := &tgbotapi.User{ID: 42, FirstName: "User"}
+	private := &tgbotapi.Message{MessageID: 1, From: user, Chat: tgbotapi.Chat{ID: 42, Type: "private"}, Text: "help"}
+	staff := func(text string, threadID int) *tgbotapi.Message {
+		var entities []tgbotapi.MessageEntity
+		if text[0] == '/' {
+			entities = []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(text)}}
+		}
+		return &tgbotapi.Message{
+			MessageID:       5,
+			From:            &tgbotapi.User{ID: 11},
+			Chat:            tgbotapi.Chat{ID: -1001234, Type: "supergroup", IsForum: true},
+			IsTopicMessage:  true,
+			MessageThreadID: threadID,
+			Text:            text,
+			Entities:        entities,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		message *tgbotapi.Message
+		want    []string
+	}{
+		{"first message", private, []string{"createForumTopic -1001234 ", "forwardMessage -1001234 7"}},
+		{"second message", private, []string{"forwardMessage -1001234 7"}},
+		{"staff reply", staff("hi", 7), []string{"copyMessage 42 "}},
+		{"other topic", staff("hi", 8), nil},
+		{"close", staff("/close", 7), []string{"closeForumTopic -1001234 7"}},
+		{"reply to closed ticket", staff("hi", 7), []string{"sendMessage -1001234 7"}},
+		{"message to closed ticket", private, []string{"reopenForumTopic -1001234 7", "forwardMessage -1001234 7"}},
+		{"block", staff("/block", 7), []string{"sendMessage -1001234 7"}},
+		{"blocked user", private, nil},
+	}
+
+	for _, tt := range tests {
+		sent = nil
+		handleModmail(escarbot, tt.message)
+		if !slices.Equal(sent, tt.want) {
+			t.Errorf("%s: sent %q, want %q", tt.name, sent, tt.want)
+		}
+	}
+
+	if tickets := OpenTickets(escarbot); len(tickets) != 1 || tickets[0].ThreadID != 7 || tickets[0].Link() != "https://t.me/c/1234/7" {
+		t.Errorf("OpenTickets() = %+v", tickets)
+	}
+}
@@ -0,0 +1,128 @@
+package telegram
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// AutoPin is a message pinned by a route. It is unpinned once Keep newer
+// messages were auto-pinned in its chat, or after Expires.
+type AutoPin struct {
+	MessageID int       `json:"message_id"`
+	PinnedAt  time.Time `json:"pinned_at"`
+	Keep      int       `json:"keep,omitempty"`    // 0 for no limit
+	Expires   time.Time `json:"expires,omitempty"` // zero for never
+}
+
+// pinMu serializes the updates of the auto pin bookkeeping.
+var pinMu sync.Mutex
+
+// pinMatches reports whether a post sent by r should be pinned.
+func (r Route) pinMatches(message *tgbotapi.Message) bool {
+	if !r.Pin {
+		return false
+	}
+	if len(r.PinHashtags) == 0 {
+		return true
+	}
+	tags := messageHashtags(message)
+	return slices.ContainsFunc(r.PinHashtags, func(tag string) bool { return slices.Contains(tags, tag) })
+}
+
+// autoPin pins message msgID, sent by route, and unpins the auto pins of the
+// chat that it pushes out.
+func autoPin(escarbot *EscarBot, route Route, msgID int) {
+	pin := tgbotapi.PinChatMessageConfig{
+		BaseChatMessage:     tgbotapi.BaseChatMessage{ChatConfig: tgbotapi.ChatConfig{ChatID: route.Target}, MessageID: msgID},
+		DisableNotification: true,
+	}
+	if _, err := escarbot.Bot.Request(pin); err != nil {
+		log.Printf("Error pinning message %d in %d: %v", msgID, route.Target, err)
+		return
+	}
+
+	now := time.Now()
+	record := AutoPin{MessageID: msgID, PinnedAt: now, Keep: route.PinKeep}
+	if route.PinHours > 0 {
+		record.Expires = now.Add(time.Duration(route.PinHours) * time.Hour)
+	}
+
+	pinMu.Lock()
+	defer pinMu.Unlock()
+	pins := append(escarbot.Cache.GetPins(route.Target), record)
+	escarbot.Cache.SetPins(route.Target, rotatePins(escarbot, route.Target, pins, now))
+}
+
+// rotatePins unpins the pins of chatID that expired or were pushed out by
+// newer ones, and returns the remaining ones. The caller must hold pinMu.
+func rotatePins(escarbot *EscarBot, chatID int64, pins []AutoPin, now time.Time) []AutoPin {
+	var kept []AutoPin
+	for i, pin := range pins {
+		newer := len(pins) - 1 - i
+		if (pin.Keep > 0 && newer >= pin.Keep) || (!pin.Expires.IsZero() && !now.Before(pin.Expires)) {
+			unpin := tgbotapi.UnpinChatMessageConfig{
+				BaseChatMessage: tgbotapi.BaseChatMessage{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}, MessageID: pin.MessageID},
+			}
+			// The message may be gone or unpinned already: forget it anyway.
+			if _, err := escarbot.Bot.Request(unpin); err != nil {
+				log.Printf("Error unpinning message %d in %d: %v", pin.MessageID, chatID, err)
+			}
+			continue
+		}
+		kept = append(kept, pin)
+	}
+	return kept
+}
+
+// replacePin moves the auto pin of message oldID of chatID to newID, which
+// replaced it. It does nothing if oldID is not auto-pinned.
+func replacePin(escarbot *EscarBot, chatID int64, oldID, newID int) {
+	pinMu.Lock()
+	defer pinMu.Unlock()
+	pins := escarbot.Cache.GetPins(chatID)
+	i := slices.IndexFunc(pins, func(p AutoPin) bool { return p.MessageID == oldID })
+	if i < 0 {
+		return
+	}
+	if newID != 0 {
+		pin := tgbotapi.PinChatMessageConfig{
+			BaseChatMessage:     tgbotapi.BaseChatMessage{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}, MessageID: newID},
+			DisableNotification: true,
+		}
+		if _, err := escarbot.Bot.Request(pin); err != nil {
+			log.Printf("Error pinning message %d in %d: %v", newID, chatID, err)
+			newID = 0
+		}
+	}
+	if newID == 0 {
+		pins = slices.Delete(pins, i, i+1)
+	} else {
+		pins[i].MessageID = newID
+	}
+	escarbot.Cache.SetPins(chatID, pins)
+}
+
+// rotateAllPins unpins the expired auto pins of every chat.
+func rotateAllPins(escarbot *EscarBot, now time.Time) {
+	pinMu.Lock()
+	defer pinMu.Unlock()
+	for _, chatID := range escarbot.Cache.GetPinnedChats() {
+		pins := escarbot.Cache.GetPins(chatID)
+		escarbot.Cache.SetPins(chatID, rotatePins(escarbot, chatID, pins, now))
+	}
+}
+
+// WatchPins unpins the expired auto pins every interval, starting with the
+// ones that expired while the bot was down. It never returns.
+func WatchPins(escarbot *EscarBot, interval time.Duration) {
+	rotateAllPins(escarbot, time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		rotateAllPins(escarbot, now)
+	}
+}
@@ -0,0 +1,85 @@
+package telegram
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestAutoPin(t *testing.T) {
+	var mu sync.Mutex
+	var pinned, unpinned []int
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		id, _ := strconv.Atoi(form.Get("message_id"))
+		switch method {
+		case "pinChatMessage":
+			pinned = append(pinned, id)
+		case "unpinChatMessage":
+			unpinned = append(unpinned, id)
+		}
+		return true, "true"
+	})
+
+	annuncio := &tgbotapi.Message{Text: "#annuncio", Entities: []tgbotapi.MessageEntity{{Type: "hashtag", Offset: 0, Length: 9}}}
+	tests := []struct {
+		name         string
+		route        Route
+		posts        int
+		after        time.Duration
+		wantPinned   []int
+		wantUnpinned []int
+		wantKept     []int
+	}{
+		{"no limit", Route{Pin: true}, 3, 0, []int{1, 2, 3}, nil, []int{1, 2, 3}},
+		{"keep two", Route{Pin: true, PinKeep: 2}, 4, 0, []int{1, 2, 3, 4}, []int{1, 2}, []int{3, 4}},
+		{"expired", Route{Pin: true, PinHours: 1}, 2, 2 * time.Hour, []int{1, 2}, []int{1, 2}, nil},
+		{"not expired", Route{Pin: true, PinHours: 3}, 2, 2 * time.Hour, []int{1, 2}, nil, []int{1, 2}},
+		{"hashtag filter", Route{Pin: true, PinHashtags: []string{"other"}}, 2, 0, nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinned, unpinned = nil, nil
+			escarbot := &EscarBot{Bot: api, Cache: NewCache("")}
+			tt.route.Target = -100
+
+			for id := 1; id <= tt.posts; id++ {
+				if tt.route.pinMatches(annuncio) {
+					autoPin(escarbot, tt.route, id)
+				}
+			}
+			rotateAllPins(escarbot, time.Now().Add(tt.after))
+
+			var kept []int
+			for _, pin := range escarbot.Cache.GetPins(-100) {
+				kept = append(kept, pin.MessageID)
+			}
+			if !slices.Equal(pinned, tt.wantPinned) || !slices.Equal(unpinned, tt.wantUnpinned) || !slices.Equal(kept, tt.wantKept) {
+				t.Errorf("pinned %v, unpinned %v, kept %v; want %v, %v, %v", pinned, unpinned, kept, tt.wantPinned, tt.wantUnpinned, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestReplacePin(t *testing.T) {
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) { return true, "true" })
+	escarbot := &EscarBot{Bot: api, Cache: NewCache("")}
+	escarbot.Cache.SetPins(-100, []AutoPin{{MessageID: 1}, {MessageID: 2}})
+
+	replacePin(escarbot, -100, 1, 5)
+	replacePin(escarbot, -100, 2, 0)
+	replacePin(escarbot, -100, 9, 10)
+	if pins := escarbot.Cache.GetPins(-100); len(pins) != 1 || pins[0].MessageID != 5 {
+		t.Errorf("pins = %+v, want message 5 only", pins)
+	}
+	if chats := escarbot.Cache.GetPinnedChats(); !slices.Equal(chats, []int64{-100}) {
+		t.Errorf("GetPinnedChats() = %v", chats)
+	}
+}
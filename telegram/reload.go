@@ -0,0 +1,166 @@
+package telegram
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// reloadOperator is the operator name used in audit events for changes that
+// come from the config file.
+const reloadOperator = "config file"
+
+// WatchConfig polls the env file at path every interval and applies the
+// settings changed in it to escarbot. environ is the process environment as
+// it was before path was loaded into it, and current is the configuration the
+// bot was started with. It never returns.
+func WatchConfig(escarbot *EscarBot, path string, environ []string, interval time.Duration, current Config) {
+	log.Printf("Watching %s for configuration changes every %s", path, interval)
+	lastMod := modTime(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		mod := modTime(path)
+		if mod.Equal(lastMod) {
+			continue
+		}
+		lastMod = mod
+
+		next, err := readConfigFile(path, environ)
+		if err != nil {
+			log.Printf("Config reload: ignoring %s: %v", path, err)
+			continue
+		}
+		if _, err := reloadConfig(escarbot, current, next); err != nil {
+			log.Printf("Config reload: ignoring %s: %v", path, err)
+			continue
+		}
+		current = next
+	}
+}
+
+// modTime returns the modification time of path, or the zero time if it
+// cannot be read.
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// readConfigFile loads the configuration from the env file at path, falling
+// back to environ for keys it does not set. environ must not hold the values
+// loaded from path at startup, or keys removed from the file would keep them.
+func readConfigFile(path string, environ []string) (Config, error) {
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return Config{}, err
+	}
+	fallback := make(map[string]string, len(environ))
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			fallback[key] = value
+		}
+	}
+	return loadConfig(func(key string) string {
+		if value, ok := values[key]; ok {
+			return value
+		}
+		return fallback[key]
+	})
+}
+
+// reloadConfig applies to escarbot the settings that differ between old and
+// next, leaving the ones changed from the dashboard alone. It returns the
+// names of the changed settings, or an error and changes nothing if the main
+// group or channel would become one of the additional ones.
+func reloadConfig(escarbot *EscarBot, old, next Config) ([]string, error) {
+	var events []AuditEvent
+	change := func(setting string, oldValue, newValue interface{}) {
+		oldStr, newStr := formatSettingValue(oldValue), formatSettingValue(newValue)
+		if oldStr == newStr {
+			return
+		}
+		events = append(events, AuditEvent{
+			OperatorName: reloadOperator,
+			Setting:      setting,
+			OldValue:     oldStr,
+			NewValue:     newStr,
+		})
+	}
+
+	escarbot.StateMutex.Lock()
+	settings := currentSettings(escarbot)
+	dst := reflect.ValueOf(&settings).Elem()
+	oldValues, nextValues := reflect.ValueOf(old.Settings), reflect.ValueOf(next.Settings)
+	for i := 0; i < nextValues.NumField(); i++ {
+		name := nextValues.Type().Field(i).Name
+		if nextValues.Field(i).Kind() == reflect.Map || reflect.DeepEqual(oldValues.Field(i).Interface(), nextValues.Field(i).Interface()) {
+			continue
+		}
+		change(name, dst.Field(i).Interface(), nextValues.Field(i).Interface())
+		dst.Field(i).Set(nextValues.Field(i))
+	}
+	for name, enabled := range next.Settings.EnabledReplacers {
+		if old.Settings.EnabledReplacers[name] != enabled {
+			change("Replacer "+name, settings.EnabledReplacers[name], enabled)
+			settings.EnabledReplacers[name] = enabled
+		}
+	}
+	if _, taken := settings.Groups[settings.GroupID]; taken {
+		escarbot.StateMutex.Unlock()
+		return nil, fmt.Errorf("group %d is already managed as an additional group", settings.GroupID)
+	}
+	if _, taken := settings.Channels[settings.ChannelID]; taken {
+		escarbot.StateMutex.Unlock()
+		return nil, fmt.Errorf("channel %d is already managed as an additional channel", settings.ChannelID)
+	}
+	applySettings(escarbot, settings)
+
+	if !slices.Equal(old.ChatBlacklist, next.ChatBlacklist) {
+		change("ChatBlacklist", escarbot.ChatBlacklist, next.ChatBlacklist)
+		escarbot.ChatBlacklist = next.ChatBlacklist
+	}
+	if old.LogChannelID != next.LogChannelID {
+		change("LogChannelID", escarbot.LogChannelID, next.LogChannelID)
+		escarbot.LogChannelID = next.LogChannelID
+	}
+	onReload := escarbot.OnConfigReloaded
+	escarbot.StateMutex.Unlock()
+
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	changed := make([]string, len(events))
+	for i, event := range events {
+		changed[i] = event.Setting
+		RecordAudit(escarbot, event)
+	}
+	if err := SaveSettings(escarbot); err != nil {
+		log.Printf("Config reload: error saving settings: %v", err)
+	}
+	if onReload != nil {
+		onReload(changed)
+	}
+	return changed, nil
+}
+
+// formatSettingValue renders a setting value for the audit log.
+func formatSettingValue(value interface{}) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []int64:
+		return joinIDs(v)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	env := validEnv()
+	env["BANNED_WORDS"] = "spam"
+	old, err := loadConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	escarbot := &EscarBot{Cache: NewCache("")}
+	applySettings(escarbot, old.Settings)
+	// Changed from the dashboard after startup.
+	escarbot.AutoBan = false
+	escarbot.EnabledReplacers["Reddit"] = false
+
+	env["BANNED_WORDS"] = "spam,scam"
+	env["GROUP_ID"] = "-2002"
+	env["REPLACER_TWITTER_ENABLED"] = "false"
+	env["CHAT_BLACKLIST"] = "7"
+	next, err := loadConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var notified []string
+	escarbot.OnConfigReloaded = func(changed []string) { notified = changed }
+
+	changed, err := reloadConfig(escarbot, old, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"GroupID", "BannedWords", "Replacer Twitter", "ChatBlacklist"}
+	if !slices.Equal(changed, want) || !slices.Equal(notified, want) {
+		t.Errorf("changed = %v, notified = %v, want %v", changed, notified, want)
+	}
+
+	if escarbot.GroupID != -2002 || !slices.Equal(escarbot.BannedWords, []string{"spam", "scam"}) {
+		t.Errorf("file changes not applied: GroupID = %d, BannedWords = %v", escarbot.GroupID, escarbot.BannedWords)
+	}
+	if escarbot.AutoBan || escarbot.EnabledReplacers["Reddit"] {
+		t.Errorf("dashboard changes were overwritten")
+	}
+	if escarbot.EnabledReplacers["Twitter"] || !slices.Equal(escarbot.ChatBlacklist, []int64{7}) {
+		t.Errorf("Twitter = %v, ChatBlacklist = %v", escarbot.EnabledReplacers["Twitter"], escarbot.ChatBlacklist)
+	}
+
+	events := escarbot.Cache.GetAuditEvents(10)
+	if len(events) != len(want) || events[0].OperatorName != reloadOperator {
+		t.Errorf("audit events = %+v", events)
+	}
+
+	// Reloading the same configuration is a no-op.
+	if changed, err := reloadConfig(escarbot, next, next); changed != nil || err != nil {
+		t.Errorf("reloadConfig() with no changes = %v, %v", changed, err)
+	}
+
+	// The main group cannot become one of the additional groups.
+	escarbot.Groups = map[int64]GroupSettings{-3003: {CaptchaTimeout: 120}}
+	env["GROUP_ID"] = "-3003"
+	env["BANNED_WORDS"] = "spam"
+	taken, err := loadConfig(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := reloadConfig(escarbot, next, taken); err == nil {
+		t.Errorf("reloadConfig() moved the main group onto an additional one: %v", changed)
+	}
+	if escarbot.GroupID != -2002 || !slices.Equal(escarbot.BannedWords, []string{"spam", "scam"}) {
+		t.Errorf("rejected reload applied changes: GroupID = %d, BannedWords = %v", escarbot.GroupID, escarbot.BannedWords)
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := "BOT_TOKEN=123:abc\nCHANNEL_ID=-1\nGROUP_ID=-2\nADMIN_ID=3\nLOG_CHANNEL_ID=-4\nBANNED_WORDS=foo,bar\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := readConfigFile(path, nil)
+	if err != nil {
+		t.Fatalf("readConfigFile() error = %v", err)
+	}
+	if cfg.GroupID != -2 || !slices.Equal(cfg.Settings.BannedWords, []string{"foo", "bar"}) {
+		t.Errorf("readConfigFile() = %+v", cfg)
+	}
+
+	// Keys removed from the file fall back to the original environment, not
+	// to the values loaded from the file at startup.
+	t.Setenv("BANNED_WORDS", "foo,bar")
+	t.Setenv("RULES_TEXT", "Loaded from the file")
+	if err := os.WriteFile(path, []byte(strings.Replace(content, "BANNED_WORDS=foo,bar\n", "", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = readConfigFile(path, []string{"BANNED_WORDS=baz"})
+	if err != nil {
+		t.Fatalf("readConfigFile() error = %v", err)
+	}
+	if !slices.Equal(cfg.Settings.BannedWords, []string{"baz"}) || cfg.Settings.RulesText == "Loaded from the file" {
+		t.Errorf("readConfigFile() ignored the original environment: %+v", cfg.Settings)
+	}
+
+	if err := os.WriteFile(path, []byte(content+"CAPTCHA_TIMEOUT=never\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readConfigFile(path, nil); err == nil {
+		t.Errorf("readConfigFile() accepted an invalid CAPTCHA_TIMEOUT")
+	}
+}
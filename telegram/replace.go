@@ -1,65 +1,245 @@
 package telegram
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"unicode/utf16"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
 
+// Replacer rewrites the links matching Regex with Format, a fmt string taking
+// the non-empty capture groups of the match as %s arguments.
 type Replacer struct {
-	Name   string
-	Regex  *regexp.Regexp
-	Format string
+	Name    string `json:"name"`
+	Regex   string `json:"regex"`
+	Format  string `json:"format"`
+	Enabled bool   `json:"enabled"` // groups can still turn it off with EnabledReplacers
 }
 
 const (
 	parseMode   = "markdown"
 	linkMessage = "[🔗](%s) Da %s."
-	regexFlags  = "(?i)(?m)"
+	// linksMessage lists the numberedLink of each link when there are more than one.
+	linksMessage = "%s Da %s."
+	numberedLink = "[🔗%d](%s)"
+	regexFlags   = "(?i)(?m)"
 )
 
-var replacers = []Replacer{
+// defaultReplacers seed the replacers of a new installation.
+var defaultReplacers = []Replacer{
 	{
-		Name:   "Twitter",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:www\.)?twitter\.com\/(?:#!\/)?(.*)\/status(?:es)?\/([^\/\?\s]+)`),
-		Format: "https://fxtwitter.com/%s/status/%s",
+		Name:    "Twitter",
+		Regex:   regexFlags + `https?:\/\/(?:www\.)?twitter\.com\/(?:#!\/)?(.*)\/status(?:es)?\/([^\/\?\s]+)`,
+		Format:  "https://fxtwitter.com/%s/status/%s",
+		Enabled: true,
 	},
 	{
-		Name:   "X",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:www\.)?x\.com\/(?:#!\/)?(.*)\/status(?:es)?\/([^\/\?\s]+)`),
-		Format: "https://fixupx.com/%s/status/%s",
+		Name:    "X",
+		Regex:   regexFlags + `https?:\/\/(?:www\.)?x\.com\/(?:#!\/)?(.*)\/status(?:es)?\/([^\/\?\s]+)`,
+		Format:  "https://fixupx.com/%s/status/%s",
+		Enabled: true,
 	},
 	{
-		Name:   "Bluesky",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:www\.)?bsky\.app\/(profile\/[^\?\s]+)`),
-		Format: "https://xbsky.app/%s",
+		Name:    "Bluesky",
+		Regex:   regexFlags + `https?:\/\/(?:www\.)?bsky\.app\/(profile\/[^\?\s]+)`,
+		Format:  "https://xbsky.app/%s",
+		Enabled: true,
 	},
 	{
-		Name:   "Instagram",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:www\.)?instagram\.com\/(?:reels?|p)\/([\w\-]{11})[\/\?\w=&]*`),
-		Format: "https://kksave.com/p/%s",
+		Name:    "Instagram",
+		Regex:   regexFlags + `https?:\/\/(?:www\.)?instagram\.com\/(?:reels?|p)\/([\w\-]{11})[\/\?\w=&]*`,
+		Format:  "https://kksave.com/p/%s",
+		Enabled: true,
 	},
 	{
-		Name:   "TikTok",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:(?:www)|(?:vm))?\.?tiktok\.com\/@([\w.]+)\/(?:video)\/(\d{19,})`),
-		Format: "https://www.kksave.com/@%s/video/%s",
+		Name:    "TikTok",
+		Regex:   regexFlags + `https?:\/\/(?:(?:www)|(?:vm))?\.?tiktok\.com\/@([\w.]+)\/(?:video)\/(\d{19,})`,
+		Format:  "https://www.kksave.com/@%s/video/%s",
+		Enabled: true,
 	},
 	{
-		Name:   "TikTok Short",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:(?:www)|(?:vm))?\.?tiktok\.com\/(?:t\/)?([\w]{9})\/?`),
-		Format: "https://vm.kksave.com/%s/",
+		Name:    "TikTok Short",
+		Regex:   regexFlags + `https?:\/\/(?:(?:www)|(?:vm))?\.?tiktok\.com\/(?:t\/)?([\w]{9})\/?`,
+		Format:  "https://vm.kksave.com/%s/",
+		Enabled: true,
 	},
 	{
-		Name:   "Reddit",
-		Regex:  regexp.MustCompile(regexFlags + `https?:\/\/(?:(?:www|old)\.)?reddit\.com\/((?:r|u|user)\/[^\?\s]+)`),
-		Format: "https://rxddit.com/%s",
+		Name:    "Reddit",
+		Regex:   regexFlags + `https?:\/\/(?:(?:www|old)\.)?reddit\.com\/((?:r|u|user)\/[^\?\s]+)`,
+		Format:  "https://rxddit.com/%s",
+		Enabled: true,
 	},
 }
 
-func GetReplacers() []Replacer {
-	return replacers
+// DefaultReplacers returns the built-in replacers, which seed the settings.
+func DefaultReplacers() []Replacer {
+	return append([]Replacer(nil), defaultReplacers...)
+}
+
+// GetReplacers returns the link replacers of escarbot.
+func GetReplacers(escarbot *EscarBot) []Replacer {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	return append([]Replacer(nil), escarbot.Replacers...)
+}
+
+// IsReplacer reports whether name is one of the link replacers of escarbot.
+func IsReplacer(escarbot *EscarBot, name string) bool {
+	return slices.ContainsFunc(GetReplacers(escarbot), func(r Replacer) bool { return r.Name == name })
+}
+
+// String describes r for the audit log.
+func (r Replacer) String() string {
+	state := "off"
+	if r.Enabled {
+		state = "on"
+	}
+	return fmt.Sprintf("/%s/ → %s (%s)", r.Regex, r.Format, state)
+}
+
+// validate reports the invalid values in r: the regex must compile and the
+// format must build an http(s) URL with at most one %s per capture group.
+func (r Replacer) validate() error {
+	var errs []error
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, errors.New("missing name"))
+	}
+	re, err := regexp.Compile(r.Regex)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid regex: %v", err))
+	}
+	verbs, err := formatVerbs(r.Format)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid format: %v", err))
+	} else {
+		if re != nil && verbs > re.NumSubexp() {
+			errs = append(errs, fmt.Errorf("format has %d %%s but the regex has %d capture groups", verbs, re.NumSubexp()))
+		}
+		args := make([]interface{}, verbs)
+		for i := range args {
+			args[i] = "x"
+		}
+		if u, err := url.Parse(fmt.Sprintf(r.Format, args...)); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("format %q does not build an http(s) URL", r.Format))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// formatVerbs counts the %s verbs of format, the only ones replacers may use
+// besides %%.
+func formatVerbs(format string) (int, error) {
+	verbs := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		switch {
+		case i == len(format):
+			return 0, errors.New("trailing %")
+		case format[i] == 's':
+			verbs++
+		case format[i] != '%':
+			return 0, fmt.Errorf("unsupported verb %%%c, use %%s", format[i])
+		}
+	}
+	return verbs, nil
+}
+
+// regexCache holds compiled regexes by pattern, so that messages do not
+// compile them again.
+type regexCache struct {
+	mu        sync.RWMutex
+	byPattern map[string]*regexp.Regexp
+}
+
+// reset compiles patterns, dropping the regexes of any other pattern.
+func (c *regexCache) reset(patterns []string) {
+	byPattern := make(map[string]*regexp.Regexp, len(patterns))
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			byPattern[pattern] = re
+		}
+	}
+	c.mu.Lock()
+	c.byPattern = byPattern
+	c.mu.Unlock()
+}
+
+// get returns the compiled regex of pattern, or nil if it is not valid.
+// Patterns missing from the cache, like those of the replacers tried from the
+// dashboard, are compiled on every call.
+func (c *regexCache) get(pattern string) *regexp.Regexp {
+	c.mu.RLock()
+	re, ok := c.byPattern[pattern]
+	c.mu.RUnlock()
+	if !ok {
+		re, _ = regexp.Compile(pattern)
+	}
+	return re
+}
+
+// replacerRegexes holds the compiled regexes of the stored replacers.
+var replacerRegexes regexCache
+
+// compileReplacers fills replacerRegexes with the regexes of replacers, the
+// stored ones.
+func compileReplacers(replacers []Replacer) {
+	patterns := make([]string, len(replacers))
+	for i, replacer := range replacers {
+		patterns[i] = replacer.Regex
+	}
+	replacerRegexes.reset(patterns)
+}
+
+// SetReplacer adds replacer, or replaces the one with the same name. It
+// returns the stored replacer and the one it replaced, if any.
+func SetReplacer(escarbot *EscarBot, replacer Replacer) (stored Replacer, old Replacer, replaced bool, err error) {
+	replacer.Name = strings.TrimSpace(replacer.Name)
+	if err := replacer.validate(); err != nil {
+		return Replacer{}, Replacer{}, false, err
+	}
+
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+	for i, existing := range escarbot.Replacers {
+		if existing.Name == replacer.Name {
+			escarbot.Replacers[i] = replacer
+			compileReplacers(escarbot.Replacers)
+			return replacer, existing, true, nil
+		}
+	}
+	escarbot.Replacers = append(escarbot.Replacers, replacer)
+	compileReplacers(escarbot.Replacers)
+	return replacer, Replacer{}, false, nil
+}
+
+// DeleteReplacer removes the replacer with the given name, and the groups'
+// toggles for it, and returns it.
+func DeleteReplacer(escarbot *EscarBot, name string) (Replacer, bool) {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+	i := slices.IndexFunc(escarbot.Replacers, func(r Replacer) bool { return r.Name == name })
+	if i < 0 {
+		return Replacer{}, false
+	}
+	replacer := escarbot.Replacers[i]
+	escarbot.Replacers = slices.Delete(escarbot.Replacers, i, i+1)
+	compileReplacers(escarbot.Replacers)
+	delete(escarbot.EnabledReplacers, name)
+	for _, group := range escarbot.Groups {
+		delete(group.EnabledReplacers, name)
+	}
+	return replacer, true
 }
 
 func isInSpoiler(entities []tgbotapi.MessageEntity, offset, length int) bool {
@@ -75,55 +255,135 @@ func isInSpoiler(entities []tgbotapi.MessageEntity, offset, length int) bool {
 	return false
 }
 
-func parseText(escarbot *EscarBot, text string, entities []tgbotapi.MessageEntity) (links []string) {
-	var rawLinks string
-	runes := []rune(text) // Convert to runes to handle emojis
+// parseText returns the fixed links for the URLs of text, after passing them
+// through resolve if it is not nil.
+func parseText(replacers []Replacer, enabledReplacers map[string]bool, text string, entities []tgbotapi.MessageEntity, resolve func(string) string) (links []string) {
+	// Check which replacers are enabled
+	replacers = slices.DeleteFunc(slices.Clone(replacers), func(r Replacer) bool {
+		enabled, exists := enabledReplacers[r.Name]
+		return !r.Enabled || (exists && !enabled)
+	})
+	urls := entityURLs(text, entities)
+	if resolve != nil {
+		for i, u := range urls {
+			urls[i] = resolve(u)
+		}
+	}
+	for _, match := range matchReplacers(replacers, urls) {
+		links = append(links, match.Link)
+	}
+	return links
+}
+
+// entityURLs returns the URLs of the url and text_link entities of text,
+// skipping the ones inside spoilers.
+func entityURLs(text string, entities []tgbotapi.MessageEntity) []string {
+	var urls []string
+	// Entity offsets count UTF-16 code units.
+	units := utf16.Encode([]rune(text))
 
 	for _, e := range entities {
 		if e.Type == "text_link" {
-			if isInSpoiler(entities, e.Offset, len(e.URL)) {
+			if isInSpoiler(entities, e.Offset, e.Length) {
 				continue
 			}
-			rawLinks += e.URL + "\n"
+			urls = append(urls, e.URL)
 		} else if e.Type == "url" {
-			if isInSpoiler(entities, e.Offset, e.Length) {
+			if isInSpoiler(entities, e.Offset, e.Length) || e.Offset+e.Length > len(units) {
 				continue
 			}
-
-			rawLinks += string(runes[e.Offset:e.Offset+e.Length]) + "\n"
+			urls = append(urls, string(utf16.Decode(units[e.Offset:e.Offset+e.Length])))
 		}
 	}
+	return urls
+}
 
-	escarbot.StateMutex.RLock()
-	enabledReplacers := make(map[string]bool)
-	for k, v := range escarbot.EnabledReplacers {
-		enabledReplacers[k] = v
+// ReplacerMatch is a link rewritten by a replacer.
+type ReplacerMatch struct {
+	Replacer string   `json:"replacer"`
+	URL      string   `json:"url"`
+	Match    string   `json:"match"`  // the part of URL matched by the regex
+	Groups   []string `json:"groups"` // its capture groups, empty ones included
+	Link     string   `json:"link"`
+}
+
+// matchReplacers rewrites each of urls with the first replacer matching it,
+// in order. URLs no replacer matches are skipped, and so are the ones
+// rewritten to a link already returned.
+func matchReplacers(replacers []Replacer, urls []string) []ReplacerMatch {
+	regexes := make([]*regexp.Regexp, len(replacers))
+	for i, replacer := range replacers {
+		regexes[i] = replacerRegexes.get(replacer.Regex)
 	}
-	escarbot.StateMutex.RUnlock()
 
-	for _, replacer := range replacers {
-		// Check if this replacer is enabled
-		if enabled, exists := enabledReplacers[replacer.Name]; exists && !enabled {
-			continue
-		}
+	var matches []ReplacerMatch
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		for i, replacer := range replacers {
+			if regexes[i] == nil {
+				continue
+			}
+			foundMatches := regexes[i].FindStringSubmatch(u)
+			if len(foundMatches) == 0 {
+				continue
+			}
+			captureGroups := foundMatches[1:]
 
-		foundMatches := replacer.Regex.FindStringSubmatch(rawLinks)
-		if len(foundMatches) == 0 {
-			continue
-		}
-		captureGroups := foundMatches[1:]
+			var formatArgs []interface{}
+			for _, match := range captureGroups {
+				if match != "" {
+					formatArgs = append(formatArgs, match)
+				}
+			}
 
-		var formatArgs []interface{}
-		for _, match := range captureGroups {
-			if match != "" {
-				formatArgs = append(formatArgs, match)
+			link := fmt.Sprintf(replacer.Format, formatArgs...)
+			if !seen[link] {
+				seen[link] = true
+				matches = append(matches, ReplacerMatch{
+					Replacer: replacer.Name,
+					URL:      u,
+					Match:    foundMatches[0],
+					Groups:   captureGroups,
+					Link:     link,
+				})
 			}
+			break
 		}
+	}
+	return matches
+}
+
+// textURLRegex finds the URLs of a plain text, standing in for the url
+// entities Telegram adds to messages.
+var textURLRegex = regexp.MustCompile(`https?://[^\s]+`)
 
-		formatted := fmt.Sprintf(replacer.Format, formatArgs...)
-		links = append(links, formatted)
+// TryReplacers runs the enabled replacers of escarbot, with candidate added
+// or replacing the one with the same name if it has a regex, on the URLs of
+// text and on urls, as link detection would do with a message. It powers the
+// dashboard's replacer sandbox.
+func TryReplacers(escarbot *EscarBot, candidate Replacer, text string, urls []string) ([]ReplacerMatch, error) {
+	replacers := slices.DeleteFunc(GetReplacers(escarbot), func(r Replacer) bool { return !r.Enabled })
+	if candidate.Regex != "" {
+		candidate.Name = strings.TrimSpace(candidate.Name)
+		if candidate.Name == "" {
+			candidate.Name = "(unsaved)"
+		}
+		if err := candidate.validate(); err != nil {
+			return nil, err
+		}
+		replacers = slices.DeleteFunc(replacers, func(r Replacer) bool { return r.Name == candidate.Name })
+		replacers = append(replacers, candidate)
 	}
-	return links
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	var candidates []string
+	for _, u := range append(textURLRegex.FindAllString(text, -1), urls...) {
+		if u = strings.TrimSpace(u); u != "" {
+			candidates = append(candidates, resolveLink(ctx, escarbot, u))
+		}
+	}
+	return matchReplacers(replacers, candidates), nil
 }
 
 func getUserMention(user tgbotapi.User) string {
@@ -136,32 +396,81 @@ func getUserMention(user tgbotapi.User) string {
 	return fmt.Sprintf("[%s](tg://user?id=%d)", name, user.ID)
 }
 
+// handleLinks answers message with its fixed links. Messages with short links
+// to resolve are handled in the background, at most resolveMaxMessages at a
+// time, so that they do not hold up the updates that follow; when every slot
+// is busy their links are fixed without resolving them.
 func handleLinks(escarbot *EscarBot, message *tgbotapi.Message) {
-	links := []string{}
+	// Chats that are not managed groups, such as private chats, use the main group's settings.
+	group, managed := GetGroupSettings(escarbot, message.Chat.ID)
+	if !group.LinkDetection {
+		return
+	}
+	// Only managed groups have their messages reposted: elsewhere, like in
+	// private chats that modmail forwards, the original must stay.
+	if !managed {
+		group.LinkMode = LinkModeReply
+	}
 
-	if len(message.Entities) > 0 {
-		textLinks := parseText(escarbot, message.Text, message.Entities)
-		links = append(links, textLinks...)
+	if !hasResolvableLinks(escarbot, message) {
+		fixLinks(escarbot, message, group, false)
+		return
+	}
+	select {
+	case resolveSlots <- struct{}{}:
+		go func() {
+			defer func() { <-resolveSlots }()
+			fixLinks(escarbot, message, group, true)
+		}()
+	default:
+		log.Printf("Too many short links being resolved, fixing message %d in %d without them", message.MessageID, message.Chat.ID)
+		fixLinks(escarbot, message, group, false)
 	}
+}
 
-	if len(message.CaptionEntities) > 0 {
-		captionLinks := parseText(escarbot, message.Caption, message.CaptionEntities)
-		links = append(links, captionLinks...)
+// fixLinks sends the fixed links of message according to group, resolving its
+// short links first if resolve is set.
+func fixLinks(escarbot *EscarBot, message *tgbotapi.Message, group GroupSettings, resolve bool) {
+	var resolver func(string) string
+	if resolve {
+		// One deadline covers all the short links of the message.
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		resolver = func(link string) string { return resolveLink(ctx, escarbot, link) }
 	}
 
+	replacers := GetReplacers(escarbot)
+	links := parseText(replacers, group.EnabledReplacers, message.Text, message.Entities, resolver)
+	for _, link := range parseText(replacers, group.EnabledReplacers, message.Caption, message.CaptionEntities, resolver) {
+		if !slices.Contains(links, link) {
+			links = append(links, link)
+		}
+	}
 	if len(links) == 0 {
 		return
 	}
+	if group.LinkMode == LinkModeReplace && repostLinks(escarbot, message, links) {
+		return
+	}
 
-	user := getUserMention(*message.From)
-	bot := escarbot.Bot
-
-	for _, link := range links {
-		text := fmt.Sprintf(linkMessage, link, user)
-		msg := tgbotapi.NewMessage(message.Chat.ID, text)
-		msg.MessageThreadID = message.MessageThreadID
-		msg.ParseMode = parseMode
-		bot.Send(msg)
+	msg := tgbotapi.NewMessage(message.Chat.ID, linkReply(links, getUserMention(*message.From)))
+	msg.MessageThreadID = message.MessageThreadID
+	msg.ParseMode = parseMode
+	msg.ReplyMarkup = linkDeleteButton(message.From.ID)
+	if _, err := escarbot.Bot.Send(msg); err != nil {
+		log.Printf("Error sending fixed links to %d: %v", message.Chat.ID, err)
 	}
+}
 
+// linkReply is the text of the reply with the fixed links of a message by
+// user, numbering them when there are more than one.
+func linkReply(links []string, user string) string {
+	if len(links) == 1 {
+		return fmt.Sprintf(linkMessage, links[0], user)
+	}
+	numbered := make([]string, len(links))
+	for i, link := range links {
+		numbered[i] = fmt.Sprintf(numberedLink, i+1, link)
+	}
+	return fmt.Sprintf(linksMessage, strings.Join(numbered, " "), user)
 }
@@ -1,9 +1,11 @@
 package telegram
 
 import (
+	"net/url"
 	"reflect"
 	"strings"
 	"testing"
+	"unicode/utf16"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 )
@@ -75,6 +77,12 @@ func TestParseText(t *testing.T) {
 			url:  "https://vm.tiktok.com/ZMeXXXXXX/",
 			want: []string{"https://vm.kksave.com/ZMeXXXXXX/"},
 		},
+		{
+			name: "Link after an emoji",
+			text: "😂 https://x.com/jack/status/20",
+			url:  "https://x.com/jack/status/20",
+			want: []string{"https://fixupx.com/jack/status/20"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -84,14 +92,260 @@ func TestParseText(t *testing.T) {
 				t.Fatalf("URL not found in text")
 			}
 			entities := []tgbotapi.MessageEntity{
-				{Type: "url", Offset: offset, Length: len(tt.url)},
+				{Type: "url", Offset: utf16Len(tt.text[:offset]), Length: utf16Len(tt.url)},
 			}
-			bot := &EscarBot{
-				EnabledReplacers: make(map[string]bool),
+			if got := parseText(DefaultReplacers(), map[string]bool{}, tt.text, entities, nil); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseText() = %v, want %v", got, tt.want)
 			}
-			if got := parseText(bot, tt.text, entities); !reflect.DeepEqual(got, tt.want) {
+		})
+	}
+}
+
+func TestSetReplacer(t *testing.T) {
+	escarbot := &EscarBot{
+		Replacers:        DefaultReplacers(),
+		EnabledReplacers: map[string]bool{"Reddit": false},
+		Groups:           map[int64]GroupSettings{-101: {EnabledReplacers: map[string]bool{"Reddit": true}}},
+	}
+
+	tests := []struct {
+		name     string
+		replacer Replacer
+		wantErr  string
+	}{
+		{"missing name", Replacer{Regex: `a(b)`, Format: "https://a/%s"}, "missing name"},
+		{"bad regex", Replacer{Name: "Bad", Regex: `a(`, Format: "https://a/%s"}, "invalid regex"},
+		{"bad verb", Replacer{Name: "Bad", Regex: `a(b)`, Format: "https://a/%d"}, "unsupported verb %d"},
+		{"too many verbs", Replacer{Name: "Bad", Regex: `a(b)`, Format: "https://a/%s/%s"}, "1 capture groups"},
+		{"not a URL", Replacer{Name: "Bad", Regex: `a(b)`, Format: "%s"}, "http(s) URL"},
+		{"new", Replacer{Name: " Threads ", Regex: `threads\.net/(@\w+)`, Format: "https://fixthreads.net/%s", Enabled: true}, ""},
+		{"replace", Replacer{Name: "Twitter", Regex: defaultReplacers[0].Regex, Format: "https://vxtwitter.com/%s/status/%s"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := SetReplacer(escarbot, tt.replacer)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("SetReplacer() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	replacers := GetReplacers(escarbot)
+	if len(replacers) != len(defaultReplacers)+1 || replacers[0].Enabled || replacers[len(replacers)-1].Name != "Threads" {
+		t.Errorf("GetReplacers() = %+v", replacers)
+	}
+	if defaultReplacers[0].Format != "https://fxtwitter.com/%s/status/%s" {
+		t.Errorf("SetReplacer() modified the defaults")
+	}
+
+	replacerRegexes.mu.RLock()
+	_, compiled := replacerRegexes.byPattern[`threads\.net/(@\w+)`]
+	replacerRegexes.mu.RUnlock()
+	if !compiled {
+		t.Errorf("SetReplacer() did not compile the regex of the new replacer")
+	}
+
+	text := "https://www.threads.net/@zuck"
+	links := parseText(replacers, map[string]bool{}, text, []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(text)}}, nil)
+	if !reflect.DeepEqual(links, []string{"https://fixthreads.net/@zuck"}) {
+		t.Errorf("parseText() = %v", links)
+	}
+
+	if _, ok := DeleteReplacer(escarbot, "Reddit"); !ok || IsReplacer(escarbot, "Reddit") {
+		t.Errorf("DeleteReplacer() did not remove the replacer")
+	}
+	if _, toggled := escarbot.Groups[-101].EnabledReplacers["Reddit"]; toggled || len(escarbot.EnabledReplacers) != 0 {
+		t.Errorf("DeleteReplacer() left the group toggles: %v, %v", escarbot.EnabledReplacers, escarbot.Groups[-101].EnabledReplacers)
+	}
+}
+
+func TestTryReplacers(t *testing.T) {
+	escarbot := &EscarBot{Replacers: DefaultReplacers()}
+	for i := range escarbot.Replacers {
+		if escarbot.Replacers[i].Name == "Reddit" {
+			escarbot.Replacers[i].Enabled = false
+		}
+	}
+
+	tests := []struct {
+		name      string
+		candidate Replacer
+		text      string
+		urls      []string
+		want      []ReplacerMatch
+		wantErr   string
+	}{
+		{
+			name: "URL in the text",
+			text: "look https://twitter.com/jack/status/20 here",
+			want: []ReplacerMatch{{Replacer: "Twitter", URL: "https://twitter.com/jack/status/20", Match: "https://twitter.com/jack/status/20", Groups: []string{"jack", "20"}, Link: "https://fxtwitter.com/jack/status/20"}},
+		},
+		{
+			name: "text link",
+			urls: []string{"", " https://bsky.app/profile/a.net "},
+			want: []ReplacerMatch{{Replacer: "Bluesky", URL: "https://bsky.app/profile/a.net", Match: "https://bsky.app/profile/a.net", Groups: []string{"profile/a.net"}, Link: "https://xbsky.app/profile/a.net"}},
+		},
+		{
+			name: "disabled replacer",
+			text: "https://www.reddit.com/r/golang",
+		},
+		{
+			name:      "unsaved replacer",
+			candidate: Replacer{Name: "Threads", Regex: `threads\.net/(@\w+)`, Format: "https://fixthreads.net/%s"},
+			text:      "https://threads.net/@zuck",
+			want:      []ReplacerMatch{{Replacer: "Threads", URL: "https://threads.net/@zuck", Match: "threads.net/@zuck", Groups: []string{"@zuck"}, Link: "https://fixthreads.net/@zuck"}},
+		},
+		{
+			name:      "unsaved replacer overriding a disabled one",
+			candidate: Replacer{Name: "Reddit", Regex: `reddit\.com/(r/\w+)`, Format: "https://rxddit.com/%s"},
+			text:      "https://www.reddit.com/r/golang",
+			want:      []ReplacerMatch{{Replacer: "Reddit", URL: "https://www.reddit.com/r/golang", Match: "reddit.com/r/golang", Groups: []string{"r/golang"}, Link: "https://rxddit.com/r/golang"}},
+		},
+		{
+			name:      "invalid unsaved replacer",
+			candidate: Replacer{Regex: `a(`, Format: "https://a/%s"},
+			wantErr:   "invalid regex",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TryReplacers(escarbot, tt.candidate, tt.text, tt.urls)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TryReplacers() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TryReplacers() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit of entity
+// offsets.
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// urlEntities marks every http(s) URL of text, and the parts between "||"
+// pairs as spoilers, like Telegram does.
+func urlEntities(text string) (string, []tgbotapi.MessageEntity) {
+	var entities []tgbotapi.MessageEntity
+	if before, rest, ok := strings.Cut(text, "||"); ok {
+		if spoiler, after, ok := strings.Cut(rest, "||"); ok {
+			text = before + spoiler + after
+			entities = append(entities, tgbotapi.MessageEntity{Type: "spoiler", Offset: utf16Len(before), Length: utf16Len(spoiler)})
+		}
+	}
+	for _, loc := range textURLRegex.FindAllStringIndex(text, -1) {
+		entities = append(entities, tgbotapi.MessageEntity{Type: "url", Offset: utf16Len(text[:loc[0]]), Length: utf16Len(text[loc[0]:loc[1]])})
+	}
+	return text, entities
+}
+
+func TestParseTextMultipleLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "every link",
+			text: "🐦 https://twitter.com/a/status/1 https://x.com/b/status/2 https://twitter.com/c/status/3",
+			want: []string{"https://fxtwitter.com/a/status/1", "https://fixupx.com/b/status/2", "https://fxtwitter.com/c/status/3"},
+		},
+		{
+			name: "duplicates",
+			text: "https://twitter.com/a/status/1 and again https://twitter.com/a/status/1",
+			want: []string{"https://fxtwitter.com/a/status/1"},
+		},
+		{
+			name: "duplicates through different URLs",
+			text: "https://www.reddit.com/r/golang https://old.reddit.com/r/golang",
+			want: []string{"https://rxddit.com/r/golang"},
+		},
+		{
+			name: "mixed sites",
+			text: "https://bsky.app/profile/a.net https://example.com https://twitter.com/a/status/1",
+			want: []string{"https://xbsky.app/profile/a.net", "https://fxtwitter.com/a/status/1"},
+		},
+		{
+			name: "spoilers",
+			text: "https://twitter.com/a/status/1 ||https://twitter.com/b/status/2||",
+			want: []string{"https://fxtwitter.com/a/status/1"},
+		},
+		{
+			name: "no matches",
+			text: "https://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, entities := urlEntities(tt.text)
+			if got := parseText(DefaultReplacers(), map[string]bool{}, text, entities, nil); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("parseText() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestHandleLinks(t *testing.T) {
+	var sent []url.Values
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, form)
+		return true, `{"message_id":9,"date":0,"chat":{"id":-100,"type":"supergroup"}}`
+	})
+	escarbot := &EscarBot{Bot: api, GroupID: -100, LinkDetection: true, Replacers: DefaultReplacers(), EnabledReplacers: map[string]bool{}}
+
+	tests := []struct {
+		name    string
+		text    string
+		caption string
+		want    string
+	}{
+		{"one link", "https://twitter.com/a/status/1", "", "[🔗](https://fxtwitter.com/a/status/1) Da [Ness](tg://user?id=7)."},
+		{"numbered links", "https://twitter.com/a/status/1 https://bsky.app/profile/a.net", "", "[🔗1](https://fxtwitter.com/a/status/1) [🔗2](https://xbsky.app/profile/a.net) Da [Ness](tg://user?id=7)."},
+		{"caption duplicates", "https://twitter.com/a/status/1", "https://twitter.com/a/status/1 https://x.com/b/status/2", "[🔗1](https://fxtwitter.com/a/status/1) [🔗2](https://fixupx.com/b/status/2) Da [Ness](tg://user?id=7)."},
+		{"no links", "hello", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = nil
+			text, entities := urlEntities(tt.text)
+			caption, captionEntities := urlEntities(tt.caption)
+			handleLinks(escarbot, &tgbotapi.Message{
+				Text:            text,
+				Entities:        entities,
+				Caption:         caption,
+				CaptionEntities: captionEntities,
+				Chat:            tgbotapi.Chat{ID: -100, Type: "supergroup"},
+				MessageThreadID: 5,
+				From:            &tgbotapi.User{ID: 7, FirstName: "Ness"},
+			})
+			if tt.want == "" {
+				if len(sent) != 0 {
+					t.Errorf("sent %d messages, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(sent))
+			}
+			if got := sent[0].Get("text"); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+			if got := sent[0].Get("message_thread_id"); got != "5" {
+				t.Errorf("message_thread_id = %q, want 5", got)
+			}
+			if got := sent[0].Get("reply_markup"); !strings.Contains(got, `"callback_data":"linkdel:7"`) {
+				t.Errorf("reply_markup = %q, want a delete button", got)
+			}
+		})
+	}
+}
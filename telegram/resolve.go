@@ -0,0 +1,126 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+const (
+	resolveMaxHops     = 5               // redirects followed for a short link
+	resolveTimeout     = 5 * time.Second // bound on resolving all the short links of a message
+	resolveMaxMessages = 8               // messages whose short links are resolved at once
+)
+
+// resolveSlots holds a token for every message whose short links are being
+// resolved in the background.
+var resolveSlots = make(chan struct{}, resolveMaxMessages)
+
+// DefaultResolverHosts are the hosts of short and share links whose redirects
+// the resolver follows, unless RESOLVER_HOSTS says otherwise.
+var DefaultResolverHosts = []string{"vm.tiktok.com", "vt.tiktok.com", "t.co", "www.reddit.com", "reddit.com"}
+
+// resolverClient makes the requests of the resolver without following their
+// redirects, so that every hop can be checked against the allowed hosts.
+var resolverClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// NormalizeHosts lowercases hosts and trims them, dropping empty ones.
+func NormalizeHosts(hosts []string) []string {
+	var normalized []string
+	for _, host := range hosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			normalized = append(normalized, host)
+		}
+	}
+	return normalized
+}
+
+// ValidateHost reports whether host can be one of ResolverHosts.
+func ValidateHost(host string) error {
+	if host == "" || strings.ContainsAny(host, "/:@?# ") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// resolvable reports whether link is an http(s) URL on one of hosts.
+func resolvable(link string, hosts []string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	return slices.Contains(hosts, strings.ToLower(u.Hostname()))
+}
+
+// hasResolvableLinks reports whether message has links that the resolver
+// would follow.
+func hasResolvableLinks(escarbot *EscarBot, message *tgbotapi.Message) bool {
+	escarbot.StateMutex.RLock()
+	enabled, hosts := escarbot.LinkResolver, escarbot.ResolverHosts
+	escarbot.StateMutex.RUnlock()
+	if !enabled {
+		return false
+	}
+	urls := append(entityURLs(message.Text, message.Entities), entityURLs(message.Caption, message.CaptionEntities)...)
+	return slices.ContainsFunc(urls, func(u string) bool { return resolvable(u, hosts) })
+}
+
+// resolveLink returns where link leads if the resolver is on and link is on
+// one of ResolverHosts, or link itself, giving up when ctx is done. Results
+// are cached.
+func resolveLink(ctx context.Context, escarbot *EscarBot, link string) string {
+	escarbot.StateMutex.RLock()
+	enabled, hosts := escarbot.LinkResolver, escarbot.ResolverHosts
+	escarbot.StateMutex.RUnlock()
+	if !enabled || !resolvable(link, hosts) {
+		return link
+	}
+
+	if resolved, ok := escarbot.Cache.GetResolvedLink(link); ok {
+		return resolved
+	}
+	resolved, err := followRedirects(ctx, link, hosts)
+	if err != nil {
+		log.Printf("Error resolving %s: %v", link, err)
+		return link
+	}
+	escarbot.Cache.SetResolvedLink(link, resolved)
+	return resolved
+}
+
+// followRedirects follows the redirects of link as long as they stay on hosts,
+// for at most resolveMaxHops hops and until ctx is done, and returns the last
+// URL reached. Hosts outside of hosts are never contacted.
+func followRedirects(ctx context.Context, link string, hosts []string) (string, error) {
+	for hop := 0; hop < resolveMaxHops && resolvable(link, hosts); hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("User-Agent", "escarbot")
+		resp, err := resolverClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+			return link, nil
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return "", err
+		}
+		link = location.String()
+	}
+	return link, nil
+}
@@ -0,0 +1,142 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestResolveLink(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/short":
+			http.Redirect(w, r, "/share", http.StatusMovedPermanently)
+		case "/share":
+			http.Redirect(w, r, "https://twitter.com/jack/status/20?s=1", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/broken":
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	escarbot := &EscarBot{Cache: NewCache(""), LinkResolver: true, ResolverHosts: []string{"127.0.0.1"}}
+
+	tests := []struct {
+		name         string
+		link         string
+		want         string
+		wantRequests int32
+	}{
+		{"redirects", srv.URL + "/short", "https://twitter.com/jack/status/20?s=1", 2},
+		{"cached", srv.URL + "/short", "https://twitter.com/jack/status/20?s=1", 0},
+		{"no redirect", srv.URL + "/post", srv.URL + "/post", 1},
+		{"hop limit", srv.URL + "/loop", srv.URL + "/loop", int32(resolveMaxHops)},
+		{"redirect without location", srv.URL + "/broken", srv.URL + "/broken", 1},
+		{"host not allowed", strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/short", strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/short", 0},
+		{"not http", "ftp://127.0.0.1/short", "ftp://127.0.0.1/short", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			if got := resolveLink(context.Background(), escarbot, tt.link); got != tt.want {
+				t.Errorf("resolveLink() = %q, want %q", got, tt.want)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+
+	escarbot.LinkResolver = false
+	requests.Store(0)
+	if got := resolveLink(context.Background(), escarbot, srv.URL+"/post"); got != srv.URL+"/post" || requests.Load() != 0 {
+		t.Errorf("disabled resolver: resolveLink() = %q after %d requests", got, requests.Load())
+	}
+}
+
+func TestResolveLinkTimeout(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	escarbot := &EscarBot{Cache: NewCache(""), LinkResolver: true, ResolverHosts: []string{"127.0.0.1"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The links of a message share one deadline: once the first one has used
+	// it up, the others are not even requested.
+	for _, link := range []string{srv.URL + "/slow", srv.URL + "/slower"} {
+		if got := resolveLink(ctx, escarbot, link); got != link {
+			t.Errorf("resolveLink() = %q, want the link itself", got)
+		}
+		if _, ok := escarbot.Cache.GetResolvedLink(link); ok {
+			t.Errorf("failed resolution of %s was cached", link)
+		}
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestHandleLinksResolvesInBackground(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://twitter.com/jack/status/20", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	sent := make(chan string, 1)
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent <- form.Get("text")
+		return true, `{"message_id":9,"date":0,"chat":{"id":-100,"type":"supergroup"}}`
+	})
+	escarbot := &EscarBot{
+		Bot:              api,
+		Cache:            NewCache(""),
+		GroupID:          -100,
+		LinkDetection:    true,
+		LinkResolver:     true,
+		ResolverHosts:    []string{"127.0.0.1"},
+		Replacers:        DefaultReplacers(),
+		EnabledReplacers: map[string]bool{},
+	}
+
+	link := srv.URL + "/short"
+	handleLinks(escarbot, &tgbotapi.Message{
+		MessageID: 1,
+		Text:      link,
+		Entities:  []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(link)}},
+		Chat:      tgbotapi.Chat{ID: -100, Type: "supergroup"},
+		From:      &tgbotapi.User{ID: 7, FirstName: "Ness"},
+	})
+
+	select {
+	case text := <-sent:
+		if want := "[🔗](https://fxtwitter.com/jack/status/20) Da [Ness](tg://user?id=7)."; text != want {
+			t.Errorf("text = %q, want %q", text, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the fixed link of the resolved short link was never sent")
+	}
+}
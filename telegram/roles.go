@@ -0,0 +1,34 @@
+package telegram
+
+// Role is the access level of a dashboard operator.
+type Role string
+
+const (
+	RoleNone      Role = ""
+	RoleViewer    Role = "viewer"    // can watch the live message cache
+	RoleModerator Role = "moderator" // can also react, send messages and ban
+	RoleOwner     Role = "owner"     // can also change settings and roles
+)
+
+// Roles lists every assignable role from least to most privileged.
+var Roles = []Role{RoleViewer, RoleModerator, RoleOwner}
+
+func (r Role) level() int {
+	for i, role := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Allows reports whether r grants at least the privileges of required.
+func (r Role) Allows(required Role) bool {
+	return r.level() > 0 && r.level() >= required.level()
+}
+
+// ParseRole converts s to a Role, reporting whether it is a valid role.
+func ParseRole(s string) (Role, bool) {
+	role := Role(s)
+	return role, role.level() > 0
+}
@@ -0,0 +1,48 @@
+package telegram
+
+import "testing"
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		want     bool
+	}{
+		{RoleOwner, RoleOwner, true},
+		{RoleOwner, RoleViewer, true},
+		{RoleModerator, RoleViewer, true},
+		{RoleModerator, RoleOwner, false},
+		{RoleViewer, RoleModerator, false},
+		{RoleNone, RoleViewer, false},
+		{Role("admin"), RoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Allows(tt.required); got != tt.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", tt.role, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestCacheRoles(t *testing.T) {
+	cache := NewCache("") // in-memory mode
+
+	if _, ok := cache.GetRole(1); ok {
+		t.Errorf("GetRole() found a role in an empty cache")
+	}
+
+	cache.SetRole(1, RoleModerator)
+	cache.SetRole(2, RoleViewer)
+
+	if role, ok := cache.GetRole(1); !ok || role != RoleModerator {
+		t.Errorf("GetRole(1) = %q, %v, want %q", role, ok, RoleModerator)
+	}
+	if roles := cache.GetAllRoles(); len(roles) != 2 || roles[2] != RoleViewer {
+		t.Errorf("GetAllRoles() = %v", roles)
+	}
+
+	cache.DeleteRole(1)
+	if _, ok := cache.GetRole(1); ok {
+		t.Errorf("GetRole(1) still found after DeleteRole")
+	}
+}
@@ -0,0 +1,352 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// RouteMediaTypes are the media types a route can filter on.
+var RouteMediaTypes = []string{"text", "photo", "video", "animation", "audio", "document", "voice", "video_note", "sticker", "poll"}
+
+// routeIDRegex matches the valid route IDs.
+var routeIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Route sends the posts of a managed channel that pass its filters to a group
+// or forum topic. Empty filters match every post.
+type Route struct {
+	ID         string   `json:"id"`
+	Source     int64    `json:"source,string"`
+	Target     int64    `json:"target,string"`
+	ThreadID   int      `json:"thread_id,omitempty"`   // forum topic in Target, 0 for the general one
+	Hashtags   []string `json:"hashtags,omitempty"`    // lowercase, without '#'; any of them matches
+	MediaTypes []string `json:"media_types,omitempty"` // any of RouteMediaTypes
+	Pattern    string   `json:"pattern,omitempty"`     // regexp matched against the text or caption
+	Copy       bool     `json:"copy,omitempty"`        // copyMessage instead of forwardMessage
+	Template   string   `json:"template,omitempty"`    // copy mode only: HTML replacing the text or caption, see renderPostTemplate
+	Buttons    string   `json:"buttons,omitempty"`     // copy mode only: "Text|URL" lines, URLs may use {POST_LINK}
+
+	Pin         bool     `json:"pin,omitempty"`          // pin the sent posts in Target
+	PinHashtags []string `json:"pin_hashtags,omitempty"` // pin only the posts with any of these hashtags, normalized like Hashtags
+	PinKeep     int      `json:"pin_keep,omitempty"`     // unpin after this many newer auto pins in Target, 0 for no limit
+	PinHours    int      `json:"pin_hours,omitempty"`    // unpin after this many hours, 0 for never
+}
+
+// clone returns a copy of r that shares no collections with it.
+func (r Route) clone() Route {
+	r.Hashtags = append([]string(nil), r.Hashtags...)
+	r.MediaTypes = append([]string(nil), r.MediaTypes...)
+	r.PinHashtags = append([]string(nil), r.PinHashtags...)
+	return r
+}
+
+// String describes r for the audit log.
+func (r Route) String() string {
+	target := strconv.FormatInt(r.Target, 10)
+	if r.ThreadID != 0 {
+		target += "/" + strconv.Itoa(r.ThreadID)
+	}
+	parts := []string{fmt.Sprintf("%d → %s", r.Source, target)}
+	if r.Copy {
+		parts = append(parts, "copy")
+	} else {
+		parts = append(parts, "forward")
+	}
+	for _, tag := range r.Hashtags {
+		parts = append(parts, "#"+tag)
+	}
+	if len(r.MediaTypes) > 0 {
+		parts = append(parts, strings.Join(r.MediaTypes, "|"))
+	}
+	if r.Pattern != "" {
+		parts = append(parts, "/"+r.Pattern+"/")
+	}
+	if r.Template != "" {
+		parts = append(parts, "template "+strconv.Quote(r.Template))
+	}
+	if r.Buttons != "" {
+		parts = append(parts, "buttons "+strconv.Quote(r.Buttons))
+	}
+	if r.Pin {
+		pin := "pin"
+		for _, tag := range r.PinHashtags {
+			pin += " #" + tag
+		}
+		if r.PinKeep > 0 {
+			pin += fmt.Sprintf(" keep %d", r.PinKeep)
+		}
+		if r.PinHours > 0 {
+			pin += fmt.Sprintf(" for %dh", r.PinHours)
+		}
+		parts = append(parts, pin)
+	}
+	return strings.Join(parts, " ")
+}
+
+// validate reports the invalid values in r.
+func (r Route) validate() error {
+	var errs []error
+	if r.ID == "" {
+		errs = append(errs, errors.New("missing ID"))
+	} else if !routeIDRegex.MatchString(r.ID) {
+		errs = append(errs, fmt.Errorf("invalid ID %q: use letters, digits, '-' and '_'", r.ID))
+	}
+	if r.Source == 0 {
+		errs = append(errs, errors.New("missing source"))
+	}
+	if r.Target == 0 {
+		errs = append(errs, errors.New("missing target"))
+	}
+	if r.ThreadID < 0 {
+		errs = append(errs, fmt.Errorf("invalid thread ID %d", r.ThreadID))
+	}
+	for _, mediaType := range r.MediaTypes {
+		if !slices.Contains(RouteMediaTypes, mediaType) {
+			errs = append(errs, fmt.Errorf("unknown media type %q", mediaType))
+		}
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		errs = append(errs, fmt.Errorf("invalid pattern: %v", err))
+	}
+	if !r.Copy && (r.Template != "" || r.Buttons != "") {
+		errs = append(errs, errors.New("template and buttons need copy mode"))
+	}
+	for _, line := range strings.Split(r.Buttons, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.Contains(line, "|") {
+			errs = append(errs, fmt.Errorf("button %q is not in the form Text|URL", line))
+		}
+	}
+	if r.PinKeep < 0 {
+		errs = append(errs, fmt.Errorf("invalid pin limit %d", r.PinKeep))
+	}
+	if r.PinHours < 0 {
+		errs = append(errs, fmt.Errorf("invalid pin duration %d", r.PinHours))
+	}
+	if !r.Pin && (len(r.PinHashtags) > 0 || r.PinKeep != 0 || r.PinHours != 0) {
+		errs = append(errs, errors.New("pin filters need pinning"))
+	}
+	return errors.Join(errs...)
+}
+
+// NormalizeHashtags lowercases tags and strips their leading '#', dropping
+// empty ones.
+func NormalizeHashtags(tags []string) []string {
+	var normalized []string
+	for _, tag := range tags {
+		if tag = strings.ToLower(strings.TrimLeft(strings.TrimSpace(tag), "#")); tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// messageMediaType returns the RouteMediaTypes entry describing message.
+func messageMediaType(message *tgbotapi.Message) string {
+	switch {
+	case len(message.Photo) > 0:
+		return "photo"
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Animation != nil:
+		return "animation"
+	case message.Video != nil:
+		return "video"
+	case message.VideoNote != nil:
+		return "video_note"
+	case message.Voice != nil:
+		return "voice"
+	case message.Audio != nil:
+		return "audio"
+	case message.Document != nil:
+		return "document"
+	case message.Poll != nil:
+		return "poll"
+	}
+	return "text"
+}
+
+// messageHashtags returns the hashtags in the text or caption of message,
+// normalized like NormalizeHashtags.
+func messageHashtags(message *tgbotapi.Message) []string {
+	text, entities := message.Text, message.Entities
+	if text == "" {
+		text, entities = message.Caption, message.CaptionEntities
+	}
+	// Entity offsets count UTF-16 code units.
+	units := utf16.Encode([]rune(text))
+	var tags []string
+	for _, e := range entities {
+		if e.Type != "hashtag" || e.Offset+e.Length > len(units) {
+			continue
+		}
+		tags = append(tags, string(utf16.Decode(units[e.Offset:e.Offset+e.Length])))
+	}
+	return NormalizeHashtags(tags)
+}
+
+// routePatterns holds the compiled patterns of the stored routes.
+var routePatterns regexCache
+
+// compileRoutes fills routePatterns with the patterns of routes, the stored
+// ones.
+func compileRoutes(routes []Route) {
+	var patterns []string
+	for _, route := range routes {
+		if route.Pattern != "" {
+			patterns = append(patterns, route.Pattern)
+		}
+	}
+	routePatterns.reset(patterns)
+}
+
+// Matches reports whether message passes every filter of r.
+func (r Route) Matches(message *tgbotapi.Message) bool {
+	if len(r.MediaTypes) > 0 && !slices.Contains(r.MediaTypes, messageMediaType(message)) {
+		return false
+	}
+	if len(r.Hashtags) > 0 {
+		tags := messageHashtags(message)
+		if !slices.ContainsFunc(r.Hashtags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+			return false
+		}
+	}
+	if r.Pattern != "" {
+		re := routePatterns.get(r.Pattern)
+		if re == nil {
+			return false
+		}
+		text := message.Text
+		if text == "" {
+			text = message.Caption
+		}
+		if !re.MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetRoutes returns the routes of the channel source, or every route if
+// source is 0.
+func GetRoutes(escarbot *EscarBot, source int64) []Route {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	var routes []Route
+	for _, route := range escarbot.Routes {
+		if source == 0 || route.Source == source {
+			routes = append(routes, route.clone())
+		}
+	}
+	return routes
+}
+
+// SetRoute adds route, or replaces the route with the same ID. A new ID is
+// assigned if route has none. It returns the stored route and the one it
+// replaced, if any.
+func SetRoute(escarbot *EscarBot, route Route) (stored Route, old Route, replaced bool, err error) {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+
+	if route.ID == "" {
+		route.ID = newRouteID(escarbot.Routes)
+	}
+	route.Hashtags = NormalizeHashtags(route.Hashtags)
+	route.PinHashtags = NormalizeHashtags(route.PinHashtags)
+	if err := route.validate(); err != nil {
+		return Route{}, Route{}, false, err
+	}
+	if _, managed := escarbot.Channels[route.Source]; !managed && route.Source != escarbot.ChannelID {
+		return Route{}, Route{}, false, fmt.Errorf("channel %d is not managed", route.Source)
+	}
+	for i, existing := range escarbot.Routes {
+		if existing.ID == route.ID {
+			escarbot.Routes[i] = route.clone()
+			compileRoutes(escarbot.Routes)
+			return route, existing, true, nil
+		}
+	}
+	escarbot.Routes = append(escarbot.Routes, route.clone())
+	compileRoutes(escarbot.Routes)
+	return route, Route{}, false, nil
+}
+
+// newRouteID returns a time-based ID that no route in routes uses.
+func newRouteID(routes []Route) string {
+	for id := time.Now().UnixNano(); ; id++ {
+		idStr := strconv.FormatInt(id, 10)
+		if !slices.ContainsFunc(routes, func(r Route) bool { return r.ID == idStr }) {
+			return idStr
+		}
+	}
+}
+
+// DeleteRoute removes the route with the given ID and returns it.
+func DeleteRoute(escarbot *EscarBot, id string) (Route, bool) {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+	for i, route := range escarbot.Routes {
+		if route.ID == id {
+			escarbot.Routes = slices.Delete(escarbot.Routes, i, i+1)
+			compileRoutes(escarbot.Routes)
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// sendRoute forwards or copies message as route says, returning the ID of
+// the sent message.
+func sendRoute(escarbot *EscarBot, route Route, message *tgbotapi.Message) (int, error) {
+	if route.Copy {
+		return copyPost(escarbot, route, message)
+	}
+	msg := tgbotapi.NewForward(route.Target, message.Chat.ID, message.MessageID)
+	msg.MessageThreadID = route.ThreadID
+	sent, err := escarbot.Bot.Send(msg)
+	return sent.MessageID, err
+}
+
+// channelRoutes returns the routes of the channel source, or a route
+// forwarding everything to the main group if it has none.
+func channelRoutes(escarbot *EscarBot, source int64) []Route {
+	routes := GetRoutes(escarbot, source)
+	if len(routes) == 0 {
+		escarbot.StateMutex.RLock()
+		groupID := escarbot.GroupID
+		escarbot.StateMutex.RUnlock()
+		routes = []Route{{Source: source, Target: groupID}}
+	}
+	return routes
+}
+
+// routePost sends a channel post through the matching routes of its channel,
+// remembering the sent messages so that edits can follow. Channels without
+// routes forward everything to the main group.
+func routePost(escarbot *EscarBot, message *tgbotapi.Message) {
+	var copies []PostCopy
+	for _, route := range channelRoutes(escarbot, message.Chat.ID) {
+		if !route.Matches(message) {
+			continue
+		}
+		msgID, err := sendRoute(escarbot, route, message)
+		if err != nil {
+			log.Printf("Error routing post %d from %d to %d: %v", message.MessageID, message.Chat.ID, route.Target, err)
+			continue
+		}
+		copies = append(copies, PostCopy{Route: route, MessageID: msgID})
+		if route.pinMatches(message) {
+			autoPin(escarbot, route, msgID)
+		}
+	}
+	if len(copies) > 0 {
+		escarbot.Cache.SetPostCopies(message.Chat.ID, message.MessageID, copies)
+	}
+}
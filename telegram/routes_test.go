@@ -0,0 +1,151 @@
+package telegram
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// newFakeBotAPI returns a BotAPI talking to a local server that answers getMe
+// and delegates every other method to handle.
+func newFakeBotAPI(t *testing.T, handle func(method string, form url.Values) (bool, string)) *tgbotapi.BotAPI {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("Content-Type", "application/json")
+		if method == "getMe" {
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"test_bot"}}`))
+			return
+		}
+		ok, result := handle(method, r.Form)
+		if ok {
+			w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+		} else {
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":` + result + `}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	api, err := tgbotapi.NewBotAPIWithClient("test-token", srv.URL+"/bot%s/%s", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestRouteMatches(t *testing.T) {
+	post := &tgbotapi.Message{
+		Caption:         "New episode #News out now",
+		CaptionEntities: []tgbotapi.MessageEntity{{Type: "hashtag", Offset: 12, Length: 5}},
+		Video:           &tgbotapi.Video{},
+	}
+
+	tests := []struct {
+		name  string
+		route Route
+		want  bool
+	}{
+		{"no filters", Route{}, true},
+		{"hashtag", Route{Hashtags: []string{"other", "news"}}, true},
+		{"missing hashtag", Route{Hashtags: []string{"other"}}, false},
+		{"media type", Route{MediaTypes: []string{"photo", "video"}}, true},
+		{"wrong media type", Route{MediaTypes: []string{"text"}}, false},
+		{"pattern", Route{Pattern: `(?i)episode`}, true},
+		{"wrong pattern", Route{Pattern: `^Old`}, false},
+		{"every filter", Route{Hashtags: []string{"news"}, MediaTypes: []string{"video"}, Pattern: "out"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.Matches(post); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetRoute(t *testing.T) {
+	escarbot := &EscarBot{ChannelID: -100, GroupID: 100}
+
+	if _, _, _, err := SetRoute(escarbot, Route{Source: -200, Target: 100}); err == nil {
+		t.Errorf("SetRoute() accepted an unmanaged source")
+	}
+	if _, _, _, err := SetRoute(escarbot, Route{Source: -100, Target: 100, Pattern: "("}); err == nil {
+		t.Errorf("SetRoute() accepted an invalid pattern")
+	}
+	if _, _, _, err := SetRoute(escarbot, Route{Source: -100, Target: 100, Template: "{TEXT}"}); err == nil {
+		t.Errorf("SetRoute() accepted a template without copy mode")
+	}
+	if _, _, _, err := SetRoute(escarbot, Route{Source: -100, Target: 100, Copy: true, Buttons: "no url"}); err == nil {
+		t.Errorf("SetRoute() accepted a malformed button")
+	}
+	if _, _, _, err := SetRoute(escarbot, Route{ID: "x');alert(1)//", Source: -100, Target: 100}); err == nil {
+		t.Errorf("SetRoute() accepted an ID with quotes")
+	}
+	if _, _, _, err := SetRoute(escarbot, Route{Source: -100, Target: 100, PinKeep: 3}); err == nil {
+		t.Errorf("SetRoute() accepted a pin limit without pinning")
+	}
+
+	route, _, replaced, err := SetRoute(escarbot, Route{Source: -100, Target: 100, Hashtags: []string{"#News"}})
+	if err != nil || replaced || route.ID == "" || route.Hashtags[0] != "news" {
+		t.Fatalf("SetRoute() = %+v, %v, %v", route, replaced, err)
+	}
+	route.Copy = true
+	if _, old, replaced, _ := SetRoute(escarbot, route); !replaced || old.Copy {
+		t.Errorf("SetRoute() did not replace the route: %+v, %v", old, replaced)
+	}
+	if routes := GetRoutes(escarbot, -100); len(routes) != 1 || !routes[0].Copy {
+		t.Errorf("GetRoutes() = %+v", routes)
+	}
+
+	route.Pattern = `^\d+$`
+	SetRoute(escarbot, route)
+	routePatterns.mu.RLock()
+	_, compiled := routePatterns.byPattern[route.Pattern]
+	routePatterns.mu.RUnlock()
+	if !compiled {
+		t.Errorf("SetRoute() did not compile the route pattern")
+	}
+	route.Pattern = ""
+	SetRoute(escarbot, route)
+
+	changes := DiffSettings(Settings{ChannelID: -100, GroupID: 100}, GetSettings(escarbot))
+	if len(changes) != 1 || changes[0].Setting != "Route "+route.ID || !strings.Contains(changes[0].NewValue, "copy #news") {
+		t.Errorf("DiffSettings() = %+v", changes)
+	}
+
+	if _, ok := DeleteRoute(escarbot, route.ID); !ok || len(GetRoutes(escarbot, 0)) != 0 {
+		t.Errorf("DeleteRoute() did not remove the route")
+	}
+}
+
+func TestRoutePost(t *testing.T) {
+	var sent []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, method+" "+form.Get("chat_id")+" "+form.Get("message_thread_id"))
+		if method == "copyMessage" {
+			return true, `{"message_id":2}`
+		}
+		return true, `{"message_id":2,"date":0,"chat":{"id":1,"type":"group"}}`
+	})
+	escarbot := &EscarBot{Bot: api, Cache: NewCache(""), ChannelID: -100, GroupID: 100, ChannelForward: true}
+	post := &tgbotapi.Message{MessageID: 1, Chat: tgbotapi.Chat{ID: -100}, Text: "hello"}
+
+	channelPostHandler(escarbot, post)
+	if len(sent) != 1 || sent[0] != "forwardMessage 100 " {
+		t.Errorf("without routes: sent %q, want a forward to the main group", sent)
+	}
+
+	sent = nil
+	SetRoute(escarbot, Route{Source: -100, Target: 200, ThreadID: 5, Copy: true})
+	SetRoute(escarbot, Route{Source: -100, Target: 300, MediaTypes: []string{"photo"}})
+	channelPostHandler(escarbot, post)
+	if len(sent) != 1 || sent[0] != "copyMessage 200 5" {
+		t.Errorf("with routes: sent %q, want a copy to topic 5 of 200", sent)
+	}
+}
@@ -0,0 +1,279 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keySettings = "escarbot:settings"
+
+// Settings holds every EscarBot field that can be changed at runtime.
+type Settings struct {
+	LinkDetection     bool            `json:"link_detection"`
+	LinkMode          string          `json:"link_mode"`
+	LinkResolver      bool            `json:"link_resolver"`
+	ResolverHosts     []string        `json:"resolver_hosts"`
+	ChannelForward    bool            `json:"channel_forward"`
+	AdminForward      bool            `json:"admin_forward"`
+	AutoBan           bool            `json:"auto_ban"`
+	Captcha           bool            `json:"captcha"`
+	CaptchaTimeout    int             `json:"captcha_timeout"`
+	CaptchaMaxRetries int             `json:"captcha_max_retries"`
+	CaptchaText       string          `json:"captcha_text"`
+	WelcomeMessage    bool            `json:"welcome_message"`
+	WelcomeText       string          `json:"welcome_text"`
+	WelcomeLinks      string          `json:"welcome_links"`
+	WelcomePhoto      string          `json:"welcome_photo"`
+	RulesText         string          `json:"rules_text"`
+	StartText         string          `json:"start_text"`
+	AuditLog          bool            `json:"audit_log"`
+	PostDeletePolicy  string          `json:"post_delete_policy"`
+	QuietHours        string          `json:"quiet_hours"`
+	Timezone          string          `json:"timezone"`
+	ChannelID         int64           `json:"channel_id,string"`
+	GroupID           int64           `json:"group_id,string"`
+	AdminID           int64           `json:"admin_id,string"`
+	ModmailGroupID    int64           `json:"modmail_group_id,string"`
+	BannedWords       []string        `json:"banned_words"`
+	EnabledReplacers  map[string]bool `json:"enabled_replacers"`
+	Replacers         []Replacer      `json:"replacers"`
+
+	Groups   map[int64]GroupSettings   `json:"groups,omitempty"`
+	Channels map[int64]ChannelSettings `json:"channels,omitempty"`
+	Routes   []Route                   `json:"routes,omitempty"`
+}
+
+// SettingsStore persists Settings in Valkey when the cache is connected to
+// it, or in a JSON file otherwise.
+type SettingsStore struct {
+	cache *Cache
+	path  string
+	mu    sync.Mutex
+}
+
+// NewSettingsStore creates a SettingsStore using cache's Valkey connection,
+// or the JSON file at path if cache is in-memory.
+func NewSettingsStore(cache *Cache, path string) *SettingsStore {
+	return &SettingsStore{cache: cache, path: path}
+}
+
+// Load reads the stored settings into s, leaving fields that were never
+// stored untouched. It reports whether any settings were found.
+func (st *SettingsStore) Load(s *Settings) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var data []byte
+	if st.cache != nil && st.cache.client != nil {
+		val, err := st.cache.client.Get(st.cache.ctx, keySettings).Bytes()
+		if err == redis.Nil {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		data = val
+	} else {
+		val, err := os.ReadFile(st.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		data = val
+	}
+	return true, json.Unmarshal(data, s)
+}
+
+// save writes s to the store. The caller must hold st.mu.
+func (st *SettingsStore) save(s Settings) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if st.cache != nil && st.cache.client != nil {
+		return st.cache.client.Set(st.cache.ctx, keySettings, data, 0).Err()
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated file.
+	tmp, err := os.CreateTemp(filepath.Dir(st.path), ".settings-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), st.path)
+}
+
+// GetSettings returns a copy of the bot's runtime settings.
+func GetSettings(escarbot *EscarBot) Settings {
+	escarbot.StateMutex.RLock()
+	defer escarbot.StateMutex.RUnlock()
+	return currentSettings(escarbot)
+}
+
+// clone returns a copy of s that shares no collections with it.
+func (s Settings) clone() Settings {
+	enabledReplacers := make(map[string]bool, len(s.EnabledReplacers))
+	for name, enabled := range s.EnabledReplacers {
+		enabledReplacers[name] = enabled
+	}
+	s.EnabledReplacers = enabledReplacers
+	s.BannedWords = append([]string(nil), s.BannedWords...)
+	s.ResolverHosts = append([]string(nil), s.ResolverHosts...)
+	s.Replacers = append([]Replacer(nil), s.Replacers...)
+	if s.Groups != nil {
+		groups := make(map[int64]GroupSettings, len(s.Groups))
+		for id, group := range s.Groups {
+			groups[id] = group.clone()
+		}
+		s.Groups = groups
+	}
+	if s.Channels != nil {
+		channels := make(map[int64]ChannelSettings, len(s.Channels))
+		for id, channel := range s.Channels {
+			channels[id] = channel
+		}
+		s.Channels = channels
+	}
+	if s.Routes != nil {
+		routes := make([]Route, len(s.Routes))
+		for i, route := range s.Routes {
+			routes[i] = route.clone()
+		}
+		s.Routes = routes
+	}
+	return s
+}
+
+// currentSettings copies the runtime settings out of escarbot. The caller
+// must hold StateMutex.
+func currentSettings(escarbot *EscarBot) Settings {
+	return Settings{
+		LinkDetection:     escarbot.LinkDetection,
+		LinkMode:          escarbot.LinkMode,
+		LinkResolver:      escarbot.LinkResolver,
+		ResolverHosts:     escarbot.ResolverHosts,
+		ChannelForward:    escarbot.ChannelForward,
+		AdminForward:      escarbot.AdminForward,
+		AutoBan:           escarbot.AutoBan,
+		Captcha:           escarbot.Captcha,
+		CaptchaTimeout:    escarbot.CaptchaTimeout,
+		CaptchaMaxRetries: escarbot.CaptchaMaxRetries,
+		CaptchaText:       escarbot.CaptchaText,
+		WelcomeMessage:    escarbot.WelcomeMessage,
+		WelcomeText:       escarbot.WelcomeText,
+		WelcomeLinks:      escarbot.WelcomeLinks,
+		WelcomePhoto:      escarbot.WelcomePhoto,
+		RulesText:         escarbot.RulesText,
+		StartText:         escarbot.StartText,
+		AuditLog:          escarbot.AuditLog,
+		PostDeletePolicy:  escarbot.PostDeletePolicy,
+		QuietHours:        escarbot.QuietHours,
+		Timezone:          escarbot.Timezone,
+		ChannelID:         escarbot.ChannelID,
+		GroupID:           escarbot.GroupID,
+		AdminID:           escarbot.AdminID,
+		ModmailGroupID:    escarbot.ModmailGroupID,
+		BannedWords:       escarbot.BannedWords,
+		EnabledReplacers:  escarbot.EnabledReplacers,
+		Replacers:         escarbot.Replacers,
+		Groups:            escarbot.Groups,
+		Channels:          escarbot.Channels,
+		Routes:            escarbot.Routes,
+	}.clone()
+}
+
+// applySettings copies s into escarbot. The caller must hold StateMutex.
+func applySettings(escarbot *EscarBot, s Settings) {
+	s = s.clone()
+	escarbot.LinkDetection = s.LinkDetection
+	escarbot.LinkMode = s.LinkMode
+	escarbot.LinkResolver = s.LinkResolver
+	escarbot.ResolverHosts = s.ResolverHosts
+	escarbot.ChannelForward = s.ChannelForward
+	escarbot.AdminForward = s.AdminForward
+	escarbot.AutoBan = s.AutoBan
+	escarbot.Captcha = s.Captcha
+	escarbot.CaptchaTimeout = s.CaptchaTimeout
+	escarbot.CaptchaMaxRetries = s.CaptchaMaxRetries
+	escarbot.CaptchaText = s.CaptchaText
+	escarbot.WelcomeMessage = s.WelcomeMessage
+	escarbot.WelcomeText = s.WelcomeText
+	escarbot.WelcomeLinks = s.WelcomeLinks
+	escarbot.WelcomePhoto = s.WelcomePhoto
+	escarbot.RulesText = s.RulesText
+	escarbot.StartText = s.StartText
+	escarbot.AuditLog = s.AuditLog
+	escarbot.PostDeletePolicy = s.PostDeletePolicy
+	escarbot.QuietHours = s.QuietHours
+	escarbot.Timezone = s.Timezone
+	escarbot.ChannelID = s.ChannelID
+	escarbot.GroupID = s.GroupID
+	escarbot.AdminID = s.AdminID
+	escarbot.ModmailGroupID = s.ModmailGroupID
+	escarbot.BannedWords = s.BannedWords
+	escarbot.EnabledReplacers = s.EnabledReplacers
+	escarbot.Replacers = s.Replacers
+	compileReplacers(s.Replacers)
+	escarbot.Groups = s.Groups
+	escarbot.Channels = s.Channels
+	escarbot.Routes = s.Routes
+	compileRoutes(s.Routes)
+}
+
+// loadSettings overrides the env-seeded settings of escarbot with the stored
+// ones, if any.
+func loadSettings(escarbot *EscarBot) {
+	settings := currentSettings(escarbot)
+	found, err := escarbot.SettingsStore.Load(&settings)
+	if err != nil {
+		log.Printf("Error loading settings, using environment values: %v", err)
+		return
+	}
+	if !found {
+		log.Println("No stored settings found, using environment values.")
+		return
+	}
+	applySettings(escarbot, settings)
+	log.Println("Loaded stored settings.")
+}
+
+// SaveSettings persists the bot's current runtime settings.
+func SaveSettings(escarbot *EscarBot) error {
+	if escarbot.SettingsStore == nil {
+		return nil
+	}
+	// Snapshot under the store lock so concurrent saves are written in order.
+	escarbot.SettingsStore.mu.Lock()
+	defer escarbot.SettingsStore.mu.Unlock()
+	settings := GetSettings(escarbot)
+	if err := escarbot.SettingsStore.save(settings); err != nil {
+		return err
+	}
+	addSnapshot(escarbot.Cache, settings)
+	return nil
+}
+
+// addSnapshot records settings in the rollback history, unless they are the
+// same as the latest snapshot.
+func addSnapshot(cache *Cache, settings Settings) {
+	if cache == nil {
+		return
+	}
+	if latest := cache.GetSnapshots(1); len(latest) == 1 && len(DiffSettings(latest[0].Settings, settings)) == 0 {
+		return
+	}
+	cache.AddSnapshot(NewSnapshot(settings), maxSnapshots)
+}
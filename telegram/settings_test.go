@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingsStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	escarbot := &EscarBot{
+		SettingsStore:    NewSettingsStore(NewCache(""), path), // in-memory cache, settings go to the file
+		AutoBan:          true,
+		GroupID:          -100,
+		BannedWords:      []string{"spam"},
+		EnabledReplacers: map[string]bool{"Twitter": false},
+	}
+
+	// Nothing stored yet: the env-seeded values are kept.
+	loadSettings(escarbot)
+	if !escarbot.AutoBan || escarbot.GroupID != -100 {
+		t.Fatalf("loadSettings() changed settings without a stored file")
+	}
+
+	if err := SaveSettings(escarbot); err != nil {
+		t.Fatalf("SaveSettings() error = %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("settings file not written: %v", err)
+	}
+
+	// A restart with different env values picks up the stored ones, while
+	// replacers added since the last save keep their seeded default.
+	restarted := &EscarBot{
+		SettingsStore:    escarbot.SettingsStore,
+		AutoBan:          false,
+		GroupID:          -200,
+		EnabledReplacers: map[string]bool{"Twitter": true, "Reddit": true},
+	}
+	loadSettings(restarted)
+
+	if !restarted.AutoBan || restarted.GroupID != -100 {
+		t.Errorf("stored settings not applied: AutoBan = %v, GroupID = %d", restarted.AutoBan, restarted.GroupID)
+	}
+	if len(restarted.BannedWords) != 1 || restarted.BannedWords[0] != "spam" {
+		t.Errorf("BannedWords = %v", restarted.BannedWords)
+	}
+	if restarted.EnabledReplacers["Twitter"] || !restarted.EnabledReplacers["Reddit"] {
+		t.Errorf("EnabledReplacers = %v", restarted.EnabledReplacers)
+	}
+}
+
+func TestGetSettingsCopiesCollections(t *testing.T) {
+	escarbot := &EscarBot{
+		BannedWords:      []string{"spam"},
+		EnabledReplacers: map[string]bool{"Twitter": true},
+	}
+
+	settings := GetSettings(escarbot)
+	settings.BannedWords[0] = "changed"
+	settings.EnabledReplacers["Twitter"] = false
+
+	if escarbot.BannedWords[0] != "spam" || !escarbot.EnabledReplacers["Twitter"] {
+		t.Errorf("GetSettings() returned collections shared with the bot")
+	}
+}
@@ -0,0 +1,268 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// SettingsSchemaVersion is the version of the exported settings document.
+	SettingsSchemaVersion = 1
+	maxSnapshots          = 200
+)
+
+// SettingsSnapshot is a versioned copy of the runtime settings, used both for
+// export/import and for the rollback history.
+type SettingsSnapshot struct {
+	Version   int       `json:"version"`
+	ID        string    `json:"id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Settings  Settings  `json:"settings"`
+}
+
+// SettingChange describes how a single setting differs between two Settings.
+type SettingChange struct {
+	Setting  string `json:"setting"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// NewSnapshot wraps s in a snapshot taken now.
+func NewSnapshot(s Settings) SettingsSnapshot {
+	now := time.Now()
+	return SettingsSnapshot{
+		Version:   SettingsSchemaVersion,
+		ID:        strconv.FormatInt(now.UnixNano(), 10),
+		CreatedAt: now,
+		Settings:  s.clone(),
+	}
+}
+
+// ParseSnapshot decodes an exported settings document on top of base, so
+// settings missing from the document keep their current value.
+// Managed groups, channels and routes are replaced as a whole.
+func ParseSnapshot(data []byte, base Settings) (SettingsSnapshot, error) {
+	snapshot := SettingsSnapshot{Settings: base.clone()}
+	snapshot.Settings.Groups, snapshot.Settings.Channels, snapshot.Settings.Routes = nil, nil, nil
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return SettingsSnapshot{}, fmt.Errorf("invalid settings document: %w", err)
+	}
+	if snapshot.Version != SettingsSchemaVersion {
+		return SettingsSnapshot{}, fmt.Errorf("unsupported settings version %d", snapshot.Version)
+	}
+	if err := snapshot.Settings.Validate(); err != nil {
+		return SettingsSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// Validate reports every invalid value in s.
+func (s Settings) Validate() error {
+	errs := validateGroup("", GroupSettings{
+		LinkMode:          s.LinkMode,
+		CaptchaTimeout:    s.CaptchaTimeout,
+		CaptchaMaxRetries: s.CaptchaMaxRetries,
+		EnabledReplacers:  s.EnabledReplacers,
+	}, s.Replacers)
+	names := make(map[string]bool)
+	for i, replacer := range s.Replacers {
+		if err := replacer.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("replacers[%d]: %w", i, err))
+		}
+		if names[replacer.Name] {
+			errs = append(errs, fmt.Errorf("replacers[%d]: duplicate name %q", i, replacer.Name))
+		}
+		names[replacer.Name] = true
+	}
+	for i, host := range s.ResolverHosts {
+		if err := ValidateHost(host); err != nil {
+			errs = append(errs, fmt.Errorf("resolver_hosts[%d]: %w", i, err))
+		}
+	}
+	if s.PostDeletePolicy != "" && !slices.Contains(PostDeletePolicies, s.PostDeletePolicy) {
+		errs = append(errs, fmt.Errorf("post_delete_policy: unknown policy %q", s.PostDeletePolicy))
+	}
+	if _, err := ParseQuietHours(s.QuietHours); err != nil {
+		errs = append(errs, fmt.Errorf("quiet_hours: %v", err))
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("timezone: %v", err))
+	}
+	for _, id := range sortedIDs(s.Groups) {
+		prefix := fmt.Sprintf("groups.%d.", id)
+		if id == 0 || id == s.GroupID {
+			errs = append(errs, fmt.Errorf("groups: invalid group ID %d", id))
+		}
+		errs = append(errs, validateGroup(prefix, s.Groups[id], s.Replacers)...)
+	}
+	for _, id := range sortedIDs(s.Channels) {
+		if id == 0 || id == s.ChannelID {
+			errs = append(errs, fmt.Errorf("channels: invalid channel ID %d", id))
+		}
+	}
+	ids := make(map[string]bool)
+	for i, route := range s.Routes {
+		if err := route.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("routes[%d]: %w", i, err))
+		}
+		if ids[route.ID] {
+			errs = append(errs, fmt.Errorf("routes[%d]: duplicate ID %q", i, route.ID))
+		}
+		ids[route.ID] = true
+		if _, managed := s.Channels[route.Source]; !managed && route.Source != s.ChannelID {
+			errs = append(errs, fmt.Errorf("routes[%d]: channel %d is not managed", i, route.Source))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateGroup reports the invalid values in g, prefixing their names.
+// replacers are the link replacers g may toggle.
+func validateGroup(prefix string, g GroupSettings, replacers []Replacer) []error {
+	var errs []error
+	if g.CaptchaTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("%scaptcha_timeout: must be at least 1, got %d", prefix, g.CaptchaTimeout))
+	}
+	if g.CaptchaMaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("%scaptcha_max_retries: must be at least 0, got %d", prefix, g.CaptchaMaxRetries))
+	}
+	if g.LinkMode != "" && !slices.Contains(LinkModes, g.LinkMode) {
+		errs = append(errs, fmt.Errorf("%slink_mode: unknown mode %q", prefix, g.LinkMode))
+	}
+	for name := range g.EnabledReplacers {
+		if !slices.ContainsFunc(replacers, func(r Replacer) bool { return r.Name == name }) {
+			errs = append(errs, fmt.Errorf("%senabled_replacers: unknown replacer %q", prefix, name))
+		}
+	}
+	return errs
+}
+
+// DiffSettings lists the settings that differ between old and next, using
+// the same names as the audit log.
+func DiffSettings(old, next Settings) []SettingChange {
+	var changes []SettingChange
+	add := func(setting string, oldValue, newValue interface{}) {
+		oldStr, newStr := formatSettingValue(oldValue), formatSettingValue(newValue)
+		if oldStr != newStr {
+			changes = append(changes, SettingChange{Setting: setting, OldValue: oldStr, NewValue: newStr})
+		}
+	}
+
+	diffFields("", reflect.ValueOf(old), reflect.ValueOf(next), add)
+
+	for _, id := range sortedIDs(old.Groups, next.Groups) {
+		setting := fmt.Sprintf("Group %d", id)
+		oldGroup, wasManaged := old.Groups[id]
+		nextGroup, isManaged := next.Groups[id]
+		add(setting, wasManaged, isManaged)
+		if wasManaged && isManaged {
+			diffFields(setting+" ", reflect.ValueOf(oldGroup), reflect.ValueOf(nextGroup), add)
+		}
+	}
+	for _, id := range sortedIDs(old.Channels, next.Channels) {
+		setting := fmt.Sprintf("Channel %d", id)
+		oldChannel, wasManaged := old.Channels[id]
+		nextChannel, isManaged := next.Channels[id]
+		add(setting, wasManaged, isManaged)
+		if wasManaged && isManaged {
+			diffFields(setting+" ", reflect.ValueOf(oldChannel), reflect.ValueOf(nextChannel), add)
+		}
+	}
+	return changes
+}
+
+// diffRoutes compares two route lists by route ID.
+func diffRoutes(prefix string, old, next []Route, add func(setting string, oldValue, newValue interface{})) {
+	routes := make(map[string][2]string)
+	var ids []string
+	for i, list := range [][]Route{old, next} {
+		for _, route := range list {
+			pair, seen := routes[route.ID]
+			if !seen {
+				ids = append(ids, route.ID)
+			}
+			pair[i] = route.String()
+			routes[route.ID] = pair
+		}
+	}
+	for _, id := range ids {
+		add(prefix+"Route "+id, routes[id][0], routes[id][1])
+	}
+}
+
+// diffReplacers compares two replacer lists by replacer name.
+func diffReplacers(prefix string, old, next []Replacer, add func(setting string, oldValue, newValue interface{})) {
+	replacers := make(map[string][2]string)
+	var names []string
+	for i, list := range [][]Replacer{old, next} {
+		for _, replacer := range list {
+			pair, seen := replacers[replacer.Name]
+			if !seen {
+				names = append(names, replacer.Name)
+			}
+			pair[i] = replacer.String()
+			replacers[replacer.Name] = pair
+		}
+	}
+	for _, name := range names {
+		add(prefix+"Replacer rule "+name, replacers[name][0], replacers[name][1])
+	}
+}
+
+// DiffChatSettings lists the fields that differ between the settings of a
+// single group or channel.
+func DiffChatSettings[T GroupSettings | ChannelSettings](old, next T) []SettingChange {
+	var changes []SettingChange
+	diffFields("", reflect.ValueOf(old), reflect.ValueOf(next), func(setting string, oldValue, newValue interface{}) {
+		oldStr, newStr := formatSettingValue(oldValue), formatSettingValue(newValue)
+		if oldStr != newStr {
+			changes = append(changes, SettingChange{Setting: setting, OldValue: oldStr, NewValue: newStr})
+		}
+	})
+	return changes
+}
+
+// diffFields calls add for every field of the structs old and next, which
+// must have the same type. Replacer maps are compared one replacer at a time
+// and other maps are skipped.
+func diffFields(prefix string, old, next reflect.Value, add func(setting string, oldValue, newValue interface{})) {
+	for i := 0; i < next.NumField(); i++ {
+		name := next.Type().Field(i).Name
+		switch value := next.Field(i).Interface().(type) {
+		case []Route:
+			diffRoutes(prefix, old.Field(i).Interface().([]Route), value, add)
+		case []Replacer:
+			diffReplacers(prefix, old.Field(i).Interface().([]Replacer), value, add)
+		case map[string]bool:
+			oldValue := old.Field(i).Interface().(map[string]bool)
+			names := make([]string, 0, len(value))
+			for name := range value {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				add(prefix+"Replacer "+name, oldValue[name], value[name])
+			}
+		default:
+			if next.Field(i).Kind() != reflect.Map {
+				add(prefix+name, old.Field(i).Interface(), value)
+			}
+		}
+	}
+}
+
+// ReplaceSettings swaps the bot's runtime settings for s and returns what
+// changed. The caller is responsible for persisting them.
+func ReplaceSettings(escarbot *EscarBot, s Settings) []SettingChange {
+	escarbot.StateMutex.Lock()
+	defer escarbot.StateMutex.Unlock()
+	changes := DiffSettings(currentSettings(escarbot), s)
+	applySettings(escarbot, s)
+	return changes
+}
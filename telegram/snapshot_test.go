@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSnapshot(t *testing.T) {
+	base := Settings{
+		AutoBan:          true,
+		CaptchaTimeout:   120,
+		BannedWords:      []string{"spam"},
+		EnabledReplacers: map[string]bool{"Twitter": true, "Reddit": true},
+		Replacers:        DefaultReplacers(),
+	}
+
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{"not json", `nope`, "invalid settings document"},
+		{"wrong version", `{"version": 2, "settings": {}}`, "unsupported settings version"},
+		{"invalid values", `{"version": 1, "settings": {"captcha_timeout": 0, "enabled_replacers": {"Myspace": true}}}`, "captcha_timeout"},
+		{"invalid replacer", `{"version": 1, "settings": {"replacers": [{"name": "Bad", "regex": "(", "format": "https://a/%s"}]}}`, "replacers[0]: invalid regex"},
+		{"invalid link mode", `{"version": 1, "settings": {"groups": {"-101": {"captcha_timeout": 60, "link_mode": "shout"}}}}`, "groups.-101.link_mode: unknown mode"},
+		{"partial document", `{"version": 1, "settings": {"auto_ban": false, "enabled_replacers": {"Reddit": false}}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, err := ParseSnapshot([]byte(tt.doc), base)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseSnapshot() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSnapshot() error = %v", err)
+			}
+			s := snapshot.Settings
+			if s.AutoBan || s.CaptchaTimeout != 120 || !s.EnabledReplacers["Twitter"] || s.EnabledReplacers["Reddit"] {
+				t.Errorf("ParseSnapshot() settings = %+v", s)
+			}
+			if !base.EnabledReplacers["Reddit"] {
+				t.Errorf("ParseSnapshot() modified the base settings")
+			}
+		})
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	old := Settings{GroupID: -1, BannedWords: []string{"a"}, EnabledReplacers: map[string]bool{"Twitter": true}}
+	next := Settings{GroupID: -2, BannedWords: []string{"a", "b"}, EnabledReplacers: map[string]bool{"Twitter": false}}
+
+	changes := DiffSettings(old, next)
+	want := []SettingChange{
+		{"GroupID", "-1", "-2"},
+		{"BannedWords", "a", "a,b"},
+		{"Replacer Twitter", "true", "false"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("DiffSettings() = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if changes := DiffSettings(old, old); len(changes) != 0 {
+		t.Errorf("DiffSettings() of equal settings = %+v", changes)
+	}
+}
+
+func TestSaveSettingsSnapshots(t *testing.T) {
+	escarbot := &EscarBot{
+		Cache:          NewCache(""),
+		SettingsStore:  NewSettingsStore(NewCache(""), filepath.Join(t.TempDir(), "settings.json")),
+		CaptchaTimeout: 120,
+	}
+
+	SaveSettings(escarbot)
+	SaveSettings(escarbot) // unchanged, no new snapshot
+	escarbot.AutoBan = true
+	SaveSettings(escarbot)
+
+	snapshots := escarbot.Cache.GetSnapshots(10)
+	if len(snapshots) != 2 {
+		t.Fatalf("GetSnapshots() returned %d snapshots, want 2", len(snapshots))
+	}
+	if !snapshots[0].Settings.AutoBan || snapshots[1].Settings.AutoBan {
+		t.Errorf("snapshots not newest first")
+	}
+	if got, ok := escarbot.Cache.GetSnapshot(snapshots[1].ID); !ok || got.ID != snapshots[1].ID {
+		t.Errorf("GetSnapshot(%q) = %+v, %v", snapshots[1].ID, got, ok)
+	}
+}
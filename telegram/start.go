@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+// StartHandler answers a /start deep link (t.me/<bot>?start=<name>_<arg>)
+// in a private chat. arg is the part of the payload after the first '_'.
+type StartHandler func(escarbot *EscarBot, message *tgbotapi.Message, arg string)
+
+var (
+	startHandlersMu sync.RWMutex
+	startHandlers   = map[string]StartHandler{
+		"regole": sendRules,
+	}
+)
+
+// RegisterStartHandler makes handler answer the /start payloads named name,
+// replacing the previous handler for it.
+func RegisterStartHandler(name string, handler StartHandler) {
+	startHandlersMu.Lock()
+	defer startHandlersMu.Unlock()
+	startHandlers[name] = handler
+}
+
+// handleStart answers /start in private chats, dispatching its payload to the
+// registered StartHandler. It reports whether message was a /start command.
+func handleStart(escarbot *EscarBot, message *tgbotapi.Message) bool {
+	if !message.Chat.IsPrivate() || message.From == nil || message.Command() != "start" {
+		return false
+	}
+
+	name, arg, _ := strings.Cut(message.CommandArguments(), "_")
+	startHandlersMu.RLock()
+	handler, ok := startHandlers[name]
+	startHandlersMu.RUnlock()
+	if !ok {
+		handler = sendGreeting
+	}
+	handler(escarbot, message, arg)
+	return true
+}
+
+// sendGreeting answers /start without a known payload with StartText, where
+// {GROUP_ID} is the main group.
+func sendGreeting(escarbot *EscarBot, message *tgbotapi.Message, _ string) {
+	escarbot.StateMutex.RLock()
+	text, groupID := escarbot.StartText, escarbot.GroupID
+	escarbot.StateMutex.RUnlock()
+	if text == "" {
+		return
+	}
+	replyHTML(escarbot, message, replacePlaceholders(text, groupID, *message.From))
+}
+
+// sendRules answers /start regole_<groupID> with the rules of that group.
+func sendRules(escarbot *EscarBot, message *tgbotapi.Message, arg string) {
+	groupID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		sendGreeting(escarbot, message, arg)
+		return
+	}
+	group, managed := GetGroupSettings(escarbot, groupID)
+	if !managed || group.RulesText == "" {
+		replyHTML(escarbot, message, "No rules were published for this group.")
+		return
+	}
+	replyHTML(escarbot, message, replacePlaceholders(group.RulesText, groupID, *message.From))
+}
+
+// replyHTML sends text, formatted as HTML, to the chat of message.
+func replyHTML(escarbot *EscarBot, message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	if _, err := escarbot.Bot.Send(msg); err != nil {
+		log.Printf("Error answering /start of user %d: %v", message.From.ID, err)
+	}
+}
@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+)
+
+func TestHandleStart(t *testing.T) {
+	var sent []string
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		sent = append(sent, form.Get("text"))
+		return true, `{"message_id":2,"date":0,"chat":{"id":7,"type":"private"}}`
+	})
+	escarbot := &EscarBot{
+		Bot:       api,
+		GroupID:   -100,
+		RulesText: "No spam, {USER_NAME}",
+		StartText: "Hi {USER_NAME}, see regole_{GROUP_ID}",
+		Groups:    map[int64]GroupSettings{-101: {}},
+	}
+	RegisterStartHandler("ping", func(escarbot *EscarBot, message *tgbotapi.Message, arg string) {
+		replyHTML(escarbot, message, "pong "+arg)
+	})
+
+	tests := []struct {
+		name    string
+		text    string
+		chat    string
+		handled bool
+		want    string
+	}{
+		{"rules", "/start regole_-100", "private", true, "No spam, Ness"},
+		{"group without rules", "/start regole_-101", "private", true, "No rules were published for this group."},
+		{"unmanaged group", "/start regole_-999", "private", true, "No rules were published for this group."},
+		{"bad group ID", "/start regole_abc", "private", true, "Hi Ness, see regole_-100"},
+		{"registered payload", "/start ping_42", "private", true, "pong 42"},
+		{"unknown payload", "/start nope", "private", true, "Hi Ness, see regole_-100"},
+		{"no payload", "/start", "private", true, "Hi Ness, see regole_-100"},
+		{"other command", "/help", "private", false, ""},
+		{"group chat", "/start regole_-100", "supergroup", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = nil
+			command, _, _ := strings.Cut(tt.text, " ")
+			message := &tgbotapi.Message{
+				Text:     tt.text,
+				Chat:     tgbotapi.Chat{ID: 7, Type: tt.chat},
+				From:     &tgbotapi.User{ID: 7, FirstName: "Ness"},
+				Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+			}
+			if handled := handleStart(escarbot, message); handled != tt.handled {
+				t.Fatalf("handleStart() = %v, want %v", handled, tt.handled)
+			}
+			if got := strings.Join(sent, "\n"); got != tt.want {
+				t.Errorf("sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,6 @@ package telegram
 
 import (
 	"log"
-	"os"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +14,9 @@ type EscarBot struct {
 	Bot               *tgbotapi.BotAPI
 	Power             bool
 	LinkDetection     bool
+	LinkMode          string   // how link detection shows the fixed links, one of LinkModes
+	LinkResolver      bool     // follow the redirects of links on ResolverHosts before fixing them
+	ResolverHosts     []string // lowercase hosts of the short links to resolve
 	ChannelForward    bool
 	AdminForward      bool
 	AutoBan           bool
@@ -24,21 +24,36 @@ type EscarBot struct {
 	CaptchaTimeout    int
 	CaptchaMaxRetries int
 	WelcomeMessage    bool
+	AuditLog          bool   // post dashboard config changes to LogChannelID
+	PostDeletePolicy  string // what happens to the copies of deleted channel posts, one of PostDeletePolicies
+	QuietHours        string // "HH:MM-HH:MM" window holding channel posts, empty for none
+	Timezone          string // IANA time zone of QuietHours and scheduled posts
 	ChannelID         int64
 	GroupID           int64
 	AdminID           int64
+	ModmailGroupID    int64 // forum supergroup holding the modmail tickets, 0 to forward to AdminID
 	LogChannelID      int64
 	BannedWords       []string
 	StateMutex        sync.RWMutex
 	MaxCacheSize      int
 	OnMessageCached   func(CachedMessage) // Callback for when a message is cached
+	OnConfigReloaded  func([]string)      // Callback with the settings changed by a config reload
 	WelcomeText       string
 	WelcomeLinks      string
 	WelcomePhoto      string
+	RulesText         string // sent by /start regole_<GroupID>
+	StartText         string // greeting for /start without a known payload
 	CaptchaText       string
 	ChatBlacklist     []int64
-	EnabledReplacers  map[string]bool
+	EnabledReplacers  map[string]bool // per-group toggles of Replacers, missing ones are on
+	Replacers         []Replacer
+	Groups            map[int64]GroupSettings   // managed groups besides GroupID
+	Channels          map[int64]ChannelSettings // managed channels besides ChannelID
+	Routes            []Route
 	Cache             *Cache
+	SettingsStore     *SettingsStore
+
+	mediaGroups *mediaGroupBuffer // buffers channel albums, nil to route their items one by one
 }
 
 // JoinProcessedEntry represents a join event that was already processed
@@ -91,6 +106,7 @@ type CachedMessage struct {
 	ChatID             int64                    `json:"chat_id,string"`
 	ChatTitle          string                   `json:"chat_title,omitempty"`
 	ChatPhotoURL       string                   `json:"chat_photo_url,omitempty"`
+	FromID             int64                    `json:"from_id,string,omitempty"`
 	FromUsername       string                   `json:"from_username"`
 	FromFirstName      string                   `json:"from_first_name"`
 	Text               string                   `json:"text"`
@@ -150,8 +166,10 @@ func getAvailableReactions(escarbot *EscarBot, chatID int64) []string {
 	return reactions
 }
 
-func NewBot(botToken string, channelId string, groupId string, adminId, logChannelId string) *EscarBot {
-	bot, err := tgbotapi.NewBotAPI(botToken)
+// NewBot builds an EscarBot from cfg. Runtime settings stored by a previous
+// run take precedence over the ones in cfg.
+func NewBot(cfg Config) *EscarBot {
+	bot, err := tgbotapi.NewBotAPI(cfg.BotToken)
 	if err != nil {
 		log.Printf("Warning: failed to initialize bot with token: %v", err)
 		bot = &tgbotapi.BotAPI{Self: tgbotapi.User{UserName: "OfflineBot"}}
@@ -159,121 +177,27 @@ func NewBot(botToken string, channelId string, groupId string, adminId, logChann
 		log.Printf("Authorized on account %s", bot.Self.UserName)
 	}
 
-	channelIdInt, err := strconv.ParseInt(channelId, 10, 64)
-	if err != nil {
-		log.Fatal("Error while converting CHANNEL_ID to int64:", err)
-	}
-
-	groupIdInt, err := strconv.ParseInt(groupId, 10, 64)
-	if err != nil {
-		log.Fatal("Error while converting GROUP_ID to int64:", err)
-	}
-
-	adminIdInt, err := strconv.ParseInt(adminId, 10, 64)
-	if err != nil {
-		log.Fatal("Error while converting ADMIN_ID to int64:", err)
-	}
-
-	logChannelIdInt, err := strconv.ParseInt(logChannelId, 10, 64)
-	if err != nil {
-		log.Fatal("Error while converting LOG_CHANNEL_ID to int64:", err)
-	}
-
-	bannedWordsEnv := os.Getenv("BANNED_WORDS")
-	var bannedWords []string
-	if bannedWordsEnv != "" {
-		bannedWords = strings.Split(bannedWordsEnv, ",")
-		for i, word := range bannedWords {
-			bannedWords[i] = strings.TrimSpace(word)
-		}
-		log.Printf("Loaded %d banned words from BANNED_WORDS env", len(bannedWords))
-	} else {
-		bannedWords = []string{"18+"}
-		log.Printf("Using default banned words: %v", bannedWords)
-	}
-
-	linkDetection := getBoolEnv("LINK_DETECTION", true)
-	channelForward := getBoolEnv("CHANNEL_FORWARD", true)
-	adminForward := getBoolEnv("ADMIN_FORWARD", true)
-	autoBan := getBoolEnv("AUTO_BAN", true)
-	captcha := getBoolEnv("CAPTCHA", true)
-
-	captchaTimeout := 120
-	if captchaTimeoutStr := os.Getenv("CAPTCHA_TIMEOUT"); captchaTimeoutStr != "" {
-		if val, err := strconv.Atoi(captchaTimeoutStr); err == nil {
-			captchaTimeout = val
-		}
-	}
-
-	captchaMaxRetries := 2
-	if captchaMaxRetriesStr := os.Getenv("CAPTCHA_MAX_RETRIES"); captchaMaxRetriesStr != "" {
-		if val, err := strconv.Atoi(captchaMaxRetriesStr); err == nil {
-			captchaMaxRetries = val
-		}
-	}
-
-	welcomeMessage := getBoolEnv("WELCOME_MESSAGE", true)
-
-	chatBlacklistEnv := os.Getenv("CHAT_BLACKLIST")
-	var chatBlacklist []int64
-	if chatBlacklistEnv != "" {
-		for _, idStr := range strings.Split(chatBlacklistEnv, ",") {
-			if id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64); err == nil {
-				chatBlacklist = append(chatBlacklist, id)
-			}
-		}
-		log.Printf("Loaded %d chat IDs from CHAT_BLACKLIST", len(chatBlacklist))
-	}
-
-	enabledReplacers := make(map[string]bool)
-	for _, replacer := range GetReplacers() {
-		envKey := "REPLACER_" + strings.ReplaceAll(strings.ToUpper(replacer.Name), " ", "_") + "_ENABLED"
-		enabledReplacers[replacer.Name] = getBoolEnv(envKey, true)
-	}
-
-	valkeyAddr := os.Getenv("VALKEY_ADDR")
-	cache := NewCache(valkeyAddr)
+	cache := NewCache(cfg.ValkeyAddr)
 
 	escarbot := &EscarBot{
-		Bot:               bot,
-		Power:             true,
-		LinkDetection:     linkDetection,
-		ChannelForward:    channelForward,
-		AdminForward:      adminForward,
-		AutoBan:           autoBan,
-		Captcha:           captcha,
-		CaptchaTimeout:    captchaTimeout,
-		CaptchaMaxRetries: captchaMaxRetries,
-		WelcomeMessage:    welcomeMessage,
-		ChannelID:         channelIdInt,
-		GroupID:           groupIdInt,
-		AdminID:           adminIdInt,
-		LogChannelID:      logChannelIdInt,
-		BannedWords:       bannedWords,
-		MaxCacheSize:      maxCacheSize,
-		WelcomeText:       os.Getenv("WELCOME_TEXT"),
-		WelcomeLinks:      os.Getenv("WELCOME_LINKS"),
-		WelcomePhoto:      os.Getenv("WELCOME_PHOTO"),
-		CaptchaText:       os.Getenv("CAPTCHA_TEXT"),
-		ChatBlacklist:     chatBlacklist,
-		EnabledReplacers:  enabledReplacers,
-		Cache:             cache,
+		Bot:           bot,
+		Power:         true,
+		LogChannelID:  cfg.LogChannelID,
+		MaxCacheSize:  maxCacheSize,
+		ChatBlacklist: cfg.ChatBlacklist,
+		Cache:         cache,
+		SettingsStore: NewSettingsStore(cache, cfg.SettingsFile),
 	}
+	escarbot.mediaGroups = newMediaGroupBuffer(albumWindow, func(messages []*tgbotapi.Message) { deliverPost(escarbot, messages) })
+	applySettings(escarbot, cfg.Settings)
+	loadSettings(escarbot)
+	addSnapshot(cache, currentSettings(escarbot))
 
-	getAvailableReactions(escarbot, groupIdInt)
+	getAvailableReactions(escarbot, escarbot.GroupID)
 
 	return escarbot
 }
 
-// getBoolEnv reads a boolean environment variable with a default value
-func getBoolEnv(key string, defaultValue bool) bool {
-	value, err := strconv.ParseBool(os.Getenv(key))
-	if err != nil {
-		return defaultValue
-	}
-	return value
-}
-
 func BotPoll(escarbot *EscarBot) {
 	if escarbot.Bot.Token == "" || escarbot.Bot.Self.UserName == "OfflineBot" {
 		log.Println("Offline mode or invalid token, skipping BotPoll")
@@ -287,40 +211,32 @@ func BotPoll(escarbot *EscarBot) {
 	updates := bot.GetUpdatesChan(u)
 
 	for update := range updates {
-		escarbot.StateMutex.RLock()
-		linkDetection := escarbot.LinkDetection
-		adminForward := escarbot.AdminForward
-		channelForward := escarbot.ChannelForward
-		escarbot.StateMutex.RUnlock()
-
 		msg := update.Message
 		if msg != nil {
 			AddMessageToCache(escarbot, msg)
 			handleNewChatMembers(escarbot, msg)
-			if linkDetection {
-				handleLinks(escarbot, msg)
-			}
-			if adminForward {
-				forwardToAdmin(escarbot, msg)
+			if !handleStart(escarbot, msg) {
+				handleModmail(escarbot, msg)
 			}
+			// Link detection may delete the message, so it comes last.
+			handleLinks(escarbot, msg)
 		}
 		if update.CallbackQuery != nil {
-			HandleCaptchaCallback(escarbot, update.CallbackQuery)
+			HandleCallback(escarbot, update.CallbackQuery)
 		}
 		if update.ChatMember != nil {
 			handleChatMemberUpdate(escarbot, update.ChatMember)
 		}
 		if update.ChannelPost != nil {
 			AddMessageToCache(escarbot, update.ChannelPost)
-			if channelForward {
-				channelPostHandler(escarbot, update.ChannelPost)
-			}
+			channelPostHandler(escarbot, update.ChannelPost)
 		}
 		if update.EditedMessage != nil {
 			UpdateMessageInCache(escarbot, update.EditedMessage)
 		}
 		if update.EditedChannelPost != nil {
 			UpdateMessageInCache(escarbot, update.EditedChannelPost)
+			channelPostEditHandler(escarbot, update.EditedChannelPost)
 		}
 		if update.MessageReactionCount != nil {
 			updateReactionsInCache(escarbot, update.MessageReactionCount)
@@ -0,0 +1,367 @@
+package webui
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+const (
+	sessionCookieName = "escarbot_session"
+	csrfHeaderName    = "X-CSRF-Token"
+	csrfFormField     = "csrf_token"
+	sessionDuration   = 7 * 24 * time.Hour
+	loginMaxAge       = 24 * time.Hour
+)
+
+var (
+	errMissingHash  = errors.New("missing hash")
+	errInvalidHash  = errors.New("invalid hash")
+	errLoginExpired = errors.New("login data expired")
+	errNoAccess     = errors.New("user has no dashboard role")
+)
+
+// Operator is the dashboard user a request was authenticated as.
+type Operator struct {
+	ID   int64         `json:"id"`
+	Name string        `json:"name"`
+	Role telegram.Role `json:"role"`
+
+	// CSRFToken is bound to the session and must accompany every
+	// state-changing request.
+	CSRFToken string `json:"-"`
+}
+
+// CanModerate reports whether the operator may react, send messages and ban.
+func (op Operator) CanModerate() bool {
+	return op.Role.Allows(telegram.RoleModerator)
+}
+
+// IsOwner reports whether the operator may change settings and roles.
+func (op Operator) IsOwner() bool {
+	return op.Role.Allows(telegram.RoleOwner)
+}
+
+// session is the payload stored in the signed session cookie. The role is
+// looked up on every request, so revoking it takes effect immediately.
+type session struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Nonce   string `json:"nonce"`
+	Expires int64  `json:"exp"`
+}
+
+type contextKey int
+
+const operatorContextKey contextKey = iota
+
+// Auth verifies Telegram Login Widget payloads against the bot token and
+// issues signed session cookies to operators with a dashboard role.
+type Auth struct {
+	botToken string
+	admins   map[int64]bool
+	cache    *telegram.Cache
+	secret   []byte
+	now      func() time.Time
+}
+
+// NewAuth creates an Auth where the given admin user IDs are always owners and
+// everyone else gets the role stored in cache. If secret is empty a random one
+// is generated, which invalidates sessions on every restart.
+func NewAuth(botToken string, admins []int64, cache *telegram.Cache, secret string) *Auth {
+	a := &Auth{
+		botToken: botToken,
+		admins:   make(map[int64]bool, len(admins)),
+		cache:    cache,
+		secret:   []byte(secret),
+		now:      time.Now,
+	}
+	for _, id := range admins {
+		a.admins[id] = true
+	}
+	if len(a.secret) == 0 {
+		log.Println("SESSION_SECRET not set, dashboard sessions will not survive a restart.")
+		a.secret = make([]byte, 32)
+		if _, err := rand.Read(a.secret); err != nil {
+			log.Fatal("Error generating session secret:", err)
+		}
+	}
+	return a
+}
+
+// IsAdmin reports whether userID is one of the configured dashboard admins.
+func (a *Auth) IsAdmin(userID int64) bool {
+	return a.admins[userID]
+}
+
+// RoleOf returns the dashboard role of userID, or RoleNone if it has none.
+func (a *Auth) RoleOf(userID int64) telegram.Role {
+	if a.IsAdmin(userID) {
+		return telegram.RoleOwner
+	}
+	if role, ok := a.cache.GetRole(userID); ok {
+		return role
+	}
+	return telegram.RoleNone
+}
+
+// VerifyLogin checks a Telegram Login Widget payload as described in
+// https://core.telegram.org/widgets/login#checking-authorization and returns
+// the operator it belongs to.
+func (a *Auth) VerifyLogin(values url.Values) (Operator, error) {
+	hash := values.Get("hash")
+	if hash == "" {
+		return Operator{}, errMissingHash
+	}
+
+	var pairs []string
+	for key := range values {
+		if key == "hash" {
+			continue
+		}
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+	dataCheckString := strings.Join(pairs, "\n")
+
+	secretKey := sha256.Sum256([]byte(a.botToken))
+	mac := hmac.New(sha256.New, secretKey[:])
+	mac.Write([]byte(dataCheckString))
+	expected := mac.Sum(nil)
+
+	given, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(given, expected) {
+		return Operator{}, errInvalidHash
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || a.now().Sub(time.Unix(authDate, 0)) > loginMaxAge {
+		return Operator{}, errLoginExpired
+	}
+
+	id, err := strconv.ParseInt(values.Get("id"), 10, 64)
+	if err != nil {
+		return Operator{}, errInvalidHash
+	}
+	role := a.RoleOf(id)
+	if role == telegram.RoleNone {
+		return Operator{}, errNoAccess
+	}
+
+	name := strings.TrimSpace(values.Get("first_name") + " " + values.Get("last_name"))
+	if username := values.Get("username"); username != "" {
+		name = "@" + username
+	}
+	return Operator{ID: id, Name: name, Role: role}, nil
+}
+
+// sign returns the base64-encoded HMAC of payload.
+func (a *Auth) sign(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSessionCookie creates a signed session cookie for op.
+func (a *Auth) newSessionCookie(op Operator, secure bool) (*http.Cookie, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	expires := a.now().Add(sessionDuration)
+	data, err := json.Marshal(session{
+		ID:      op.ID,
+		Name:    op.Name,
+		Nonce:   base64.RawURLEncoding.EncodeToString(nonce),
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    payload + "." + a.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// parseSession returns the operator carried by the request cookie, if it is
+// correctly signed, unexpired and the user still has a dashboard role.
+func (a *Auth) parseSession(r *http.Request) (Operator, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return Operator{}, false
+	}
+	payload, signature, found := strings.Cut(cookie.Value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return Operator{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Operator{}, false
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Operator{}, false
+	}
+	if a.now().Unix() > s.Expires {
+		return Operator{}, false
+	}
+	role := a.RoleOf(s.ID)
+	if role == telegram.RoleNone {
+		return Operator{}, false
+	}
+	return Operator{ID: s.ID, Name: s.Name, Role: role, CSRFToken: a.csrfToken(s.Nonce)}, true
+}
+
+// csrfToken derives the CSRF token of the session with the given nonce.
+func (a *Auth) csrfToken(nonce string) string {
+	return a.sign("csrf|" + nonce)
+}
+
+// isSecureRequest reports whether the request reached us over HTTPS, either
+// directly or through a reverse proxy.
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// Middleware rejects requests without a valid session. The dashboard page
+// redirects to the login page, everything else gets a 401 JSON error.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, ok := a.parseSession(r)
+		if !ok {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusFound)
+				return
+			}
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		ctx := context.WithValue(r.Context(), operatorContextKey, op)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// operatorFromRequest returns the operator set by Auth.Middleware.
+func operatorFromRequest(r *http.Request) (Operator, bool) {
+	op, ok := r.Context().Value(operatorContextKey).(Operator)
+	return op, ok
+}
+
+// requireRole rejects requests from operators below the required role with a
+// 403 JSON error.
+func requireRole(required telegram.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, _ := operatorFromRequest(r)
+		if !op.Role.Allows(required) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requirePost rejects anything but POST requests with a 405 JSON error.
+func requirePost(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireCSRF rejects requests whose X-CSRF-Token header (or csrf_token form
+// field) does not match the operator's session with a 403 JSON error.
+func requireCSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, _ := operatorFromRequest(r)
+		token := r.Header.Get(csrfHeaderName)
+		if token == "" {
+			token = r.PostFormValue(csrfFormField)
+		}
+		if op.CSRFToken == "" || !hmac.Equal([]byte(token), []byte(op.CSRFToken)) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "invalid CSRF token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loginHandler serves the login page and, when Telegram redirects back with
+// the widget payload, verifies it and starts a session.
+func loginHandler(a *Auth, botUsername string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("hash") == "" {
+			renderLogin(w, botUsername, "")
+			return
+		}
+
+		op, err := a.VerifyLogin(query)
+		if err != nil {
+			log.Printf("Dashboard login rejected for user %s: %v", query.Get("id"), err)
+			w.WriteHeader(http.StatusForbidden)
+			renderLogin(w, botUsername, "Login failed: "+err.Error())
+			return
+		}
+
+		cookie, err := a.newSessionCookie(op, isSecureRequest(r))
+		if err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Error creating session", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookie)
+		log.Printf("Dashboard login: %s (%d)", op.Name, op.ID)
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
+
+// logoutHandler clears the session cookie. It is only reachable through POST
+// requests carrying the session's CSRF token, so other sites cannot log
+// operators out.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+func renderLogin(w http.ResponseWriter, botUsername, errorMessage string) {
+	data := struct {
+		BotUsername string
+		Error       string
+	}{botUsername, errorMessage}
+	if err := loginTemplate.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,266 @@
+package webui
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+const testBotToken = "123456:test-token"
+
+// signLogin computes the Telegram Login Widget hash for values.
+func signLogin(token string, values url.Values) url.Values {
+	var pairs []string
+	for key := range values {
+		pairs = append(pairs, key+"="+values.Get(key))
+	}
+	sort.Strings(pairs)
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+
+	signed := url.Values{}
+	for key := range values {
+		signed.Set(key, values.Get(key))
+	}
+	signed.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return signed
+}
+
+func loginValues(id int64, authDate time.Time) url.Values {
+	return url.Values{
+		"id":         {strconv.FormatInt(id, 10)},
+		"first_name": {"Alice"},
+		"username":   {"alice"},
+		"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+	}
+}
+
+func TestVerifyLogin(t *testing.T) {
+	auth := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "secret")
+	now := time.Now()
+
+	op, err := auth.VerifyLogin(signLogin(testBotToken, loginValues(42, now)))
+	if err != nil {
+		t.Fatalf("VerifyLogin() error = %v", err)
+	}
+	if op.ID != 42 || op.Name != "@alice" {
+		t.Errorf("VerifyLogin() = %+v", op)
+	}
+
+	tampered := signLogin(testBotToken, loginValues(42, now))
+	tampered.Set("first_name", "Mallory")
+	if _, err := auth.VerifyLogin(tampered); err != errInvalidHash {
+		t.Errorf("tampered payload: error = %v, want %v", err, errInvalidHash)
+	}
+
+	if _, err := auth.VerifyLogin(signLogin("other:token", loginValues(42, now))); err != errInvalidHash {
+		t.Errorf("wrong token: error = %v, want %v", err, errInvalidHash)
+	}
+
+	if _, err := auth.VerifyLogin(signLogin(testBotToken, loginValues(42, now.Add(-48*time.Hour)))); err != errLoginExpired {
+		t.Errorf("old payload: error = %v, want %v", err, errLoginExpired)
+	}
+
+	if _, err := auth.VerifyLogin(signLogin(testBotToken, loginValues(7, now))); err != errNoAccess {
+		t.Errorf("user without role: error = %v, want %v", err, errNoAccess)
+	}
+
+	auth.cache.SetRole(7, telegram.RoleViewer)
+	op, err = auth.VerifyLogin(signLogin(testBotToken, loginValues(7, now)))
+	if err != nil || op.Role != telegram.RoleViewer {
+		t.Errorf("viewer: operator = %+v, error = %v", op, err)
+	}
+
+	if _, err := auth.VerifyLogin(loginValues(42, now)); err != errMissingHash {
+		t.Errorf("unsigned payload: error = %v, want %v", err, errMissingHash)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "secret")
+	var seen Operator
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = operatorFromRequest(r)
+	}))
+
+	// No cookie: API routes get 401, the dashboard redirects to the login page.
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/chats", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("API without session: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/login" {
+		t.Errorf("index without session: status = %d, location = %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	cookie, err := auth.newSessionCookie(Operator{ID: 42, Name: "@alice"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || seen.ID != 42 {
+		t.Errorf("valid session: status = %d, operator = %+v", rr.Code, seen)
+	}
+
+	// A cookie signed with another secret must be rejected.
+	forged, _ := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "other").newSessionCookie(Operator{ID: 42}, false)
+	req = httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	req.AddCookie(forged)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("forged session: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Expired sessions are rejected too.
+	auth.now = func() time.Time { return time.Now().Add(sessionDuration + time.Hour) }
+	req = httptest.NewRequest(http.MethodGet, "/api/media", nil)
+	req.AddCookie(cookie)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expired session: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	auth := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "secret")
+	auth.cache.SetRole(7, telegram.RoleViewer)
+	auth.cache.SetRole(8, telegram.RoleModerator)
+
+	handler := auth.Middleware(requireRole(telegram.RoleModerator, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		userID int64
+		status int
+	}{
+		{7, http.StatusForbidden},
+		{8, http.StatusNoContent},
+		{42, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		cookie, _ := auth.newSessionCookie(Operator{ID: tt.userID}, false)
+		req := httptest.NewRequest(http.MethodPost, "/setReaction", nil)
+		req.AddCookie(cookie)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("user %d: status = %d, want %d", tt.userID, rr.Code, tt.status)
+		}
+	}
+
+	// Revoking the role invalidates the existing session.
+	cookie, _ := auth.newSessionCookie(Operator{ID: 8}, false)
+	auth.cache.DeleteRole(8)
+	req := httptest.NewRequest(http.MethodPost, "/setReaction", nil)
+	req.AddCookie(cookie)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked user: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSetRoleHandler(t *testing.T) {
+	auth := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "secret")
+	bot := &telegram.EscarBot{Cache: auth.cache}
+
+	post := func(form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/setRole", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		setRoleHandler(bot, auth).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post(url.Values{"user_id": {"7"}, "role": {"moderator"}}); code != http.StatusOK {
+		t.Errorf("assign: status = %d", code)
+	}
+	if auth.RoleOf(7) != telegram.RoleModerator {
+		t.Errorf("RoleOf(7) = %q, want moderator", auth.RoleOf(7))
+	}
+	if code := post(url.Values{"user_id": {"7"}, "role": {"god"}}); code != http.StatusBadRequest {
+		t.Errorf("invalid role: status = %d", code)
+	}
+	if code := post(url.Values{"user_id": {"42"}, "role": {"viewer"}}); code != http.StatusBadRequest {
+		t.Errorf("configured admin: status = %d", code)
+	}
+	if code := post(url.Values{"user_id": {"7"}, "role": {""}}); code != http.StatusOK {
+		t.Errorf("revoke: status = %d", code)
+	}
+	if auth.RoleOf(7) != telegram.RoleNone {
+		t.Errorf("RoleOf(7) = %q after revoke", auth.RoleOf(7))
+	}
+
+	// Only the assignment and the revocation change anything.
+	events := auth.cache.GetAuditEvents(10)
+	if len(events) != 2 || events[0].NewValue != "" || events[1].NewValue != "moderator" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestRequireCSRF(t *testing.T) {
+	auth := NewAuth(testBotToken, []int64{42}, telegram.NewCache(""), "secret")
+	handler := auth.Middleware(requirePost(requireCSRF(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	cookie, _ := auth.newSessionCookie(Operator{ID: 42}, false)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookie)
+	op, _ := auth.parseSession(req)
+	other, _ := auth.newSessionCookie(Operator{ID: 42}, false)
+	otherReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	otherReq.AddCookie(other)
+	otherOp, _ := auth.parseSession(otherReq)
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		form   url.Values
+		status int
+	}{
+		{"GET", http.MethodGet, op.CSRFToken, nil, http.StatusMethodNotAllowed},
+		{"missing token", http.MethodPost, "", nil, http.StatusForbidden},
+		{"token of another session", http.MethodPost, otherOp.CSRFToken, nil, http.StatusForbidden},
+		{"header token", http.MethodPost, op.CSRFToken, nil, http.StatusNoContent},
+		{"form token", http.MethodPost, "", url.Values{"csrf_token": {op.CSRFToken}}, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/setAutoBan", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				req.Header.Set(csrfHeaderName, tt.header)
+			}
+			req.AddCookie(cookie)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d", rr.Code, tt.status)
+			}
+		})
+	}
+}
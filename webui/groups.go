@@ -0,0 +1,166 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// managedChat is an entry of the dashboard's chat selector.
+type managedChat struct {
+	ID        int64
+	Title     string
+	IsChannel bool
+	IsMain    bool
+}
+
+// managedChats lists the managed groups and channels, main ones first.
+func managedChats(bot *telegram.EscarBot) []managedChat {
+	titles := make(map[int64]string)
+	for _, chat := range bot.Cache.GetAllChats() {
+		titles[chat.ID] = chat.Title
+	}
+	title := func(id int64) string {
+		if t, ok := titles[id]; ok && t != "" {
+			return t
+		}
+		return strconv.FormatInt(id, 10)
+	}
+
+	var chats []managedChat
+	for i, id := range telegram.ManagedGroups(bot) {
+		chats = append(chats, managedChat{ID: id, Title: title(id), IsMain: i == 0})
+	}
+	for i, id := range telegram.ManagedChannels(bot) {
+		chats = append(chats, managedChat{ID: id, Title: title(id), IsChannel: true, IsMain: i == 0})
+	}
+	return chats
+}
+
+// formChatID parses the optional "chat_id" form value, returning def when it
+// is missing.
+func formChatID(r *http.Request, def int64) (int64, error) {
+	r.ParseForm()
+	value := r.Form.Get("chat_id")
+	if value == "" {
+		return def, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// updateGroup applies update to the group picked by the "chat_id" form value,
+// the main group by default, then records and saves the changes. It reports
+// whether the settings were saved, replying with an error otherwise.
+func updateGroup(w http.ResponseWriter, r *http.Request, bot *telegram.EscarBot, update func(*telegram.GroupSettings)) bool {
+	bot.StateMutex.RLock()
+	mainGroup := bot.GroupID
+	bot.StateMutex.RUnlock()
+
+	chatID, err := formChatID(r, mainGroup)
+	if err != nil {
+		http.Error(w, "Invalid chat_id", http.StatusBadRequest)
+		return false
+	}
+	old, next, ok := telegram.UpdateGroupSettings(bot, chatID, update)
+	if !ok {
+		http.Error(w, "Unknown group", http.StatusNotFound)
+		return false
+	}
+
+	prefix := ""
+	if chatID != mainGroup {
+		prefix = fmt.Sprintf("Group %d ", chatID)
+	}
+	for _, change := range telegram.DiffChatSettings(old, next) {
+		recordChange(bot, r, prefix+change.Setting, change.OldValue, change.NewValue)
+	}
+	return saveSettings(w, bot)
+}
+
+// updateChannel applies update to the managed channel chatID, then records
+// and saves the changes.
+func updateChannel(w http.ResponseWriter, r *http.Request, bot *telegram.EscarBot, chatID int64, update func(*telegram.ChannelSettings)) bool {
+	old, next, ok := telegram.UpdateChannelSettings(bot, chatID, update)
+	if !ok {
+		http.Error(w, "Unknown channel", http.StatusNotFound)
+		return false
+	}
+	for _, change := range telegram.DiffChatSettings(old, next) {
+		recordChange(bot, r, fmt.Sprintf("Channel %d %s", chatID, change.Setting), change.OldValue, change.NewValue)
+	}
+	return saveSettings(w, bot)
+}
+
+// groupToggleHandler sets the bool field returned by field, in the group
+// picked by the "chat_id" form value, from the "toggle" form value.
+func groupToggleHandler(bot *telegram.EscarBot, field func(*telegram.GroupSettings) *bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := getToggle(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) { *field(g) = enabled })
+	}
+}
+
+// channelForwardHandler toggles forwarding for the channel picked by the
+// "chat_id" form value, the main channel by default.
+func channelForwardHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	mainChannel := toggleHandler(bot, "ChannelForward", func(b *telegram.EscarBot) *bool { return &b.ChannelForward })
+	return func(w http.ResponseWriter, r *http.Request) {
+		bot.StateMutex.RLock()
+		mainChannelID := bot.ChannelID
+		bot.StateMutex.RUnlock()
+
+		chatID, err := formChatID(r, mainChannelID)
+		if err != nil {
+			http.Error(w, "Invalid chat_id", http.StatusBadRequest)
+			return
+		}
+		if chatID == mainChannelID {
+			mainChannel(w, r)
+			return
+		}
+
+		enabled, err := getToggle(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updateChannel(w, r, bot, chatID, func(c *telegram.ChannelSettings) { c.Forward = enabled })
+	}
+}
+
+// managedChatHandler starts or stops managing the chat in the "id" form
+// value, depending on the "toggle" form value. kind names the chat type in the
+// audit log.
+func managedChatHandler(bot *telegram.EscarBot, kind string, set func(*telegram.EscarBot, int64, bool) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatID, err := getChatID(r)
+		if err != nil || chatID == 0 {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
+			return
+		}
+		managed, err := getToggle(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if set(bot, chatID, managed) {
+			recordChange(bot, r, fmt.Sprintf("%s %d", kind, chatID), !managed, managed)
+		}
+		saveSettings(w, bot)
+	}
+}
+
+func managedGroupHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return managedChatHandler(bot, "Group", telegram.SetManagedGroup)
+}
+
+func managedChannelHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return managedChatHandler(bot, "Channel", telegram.SetManagedChannel)
+}
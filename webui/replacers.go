@@ -0,0 +1,78 @@
+package webui
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// saveReplacerHandler adds a link replacer, or replaces the one with the same
+// "name", from the "regex", "format" and "enabled" form values.
+func saveReplacerHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		replacer, old, replaced, err := telegram.SetReplacer(bot, telegram.Replacer{
+			Name:    r.Form.Get("name"),
+			Regex:   r.Form.Get("regex"),
+			Format:  r.Form.Get("format"),
+			Enabled: r.Form.Get("enabled") == "on",
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		oldValue := ""
+		if replaced {
+			oldValue = old.String()
+		}
+		recordChange(bot, r, "Replacer rule "+replacer.Name, oldValue, replacer.String())
+		saveSettings(w, bot)
+	}
+}
+
+// deleteReplacerHandler removes the link replacer with the given "name".
+func deleteReplacerHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		replacer, ok := telegram.DeleteReplacer(bot, r.Form.Get("name"))
+		if !ok {
+			http.Error(w, "Unknown replacer", http.StatusNotFound)
+			return
+		}
+
+		recordChange(bot, r, "Replacer rule "+replacer.Name, replacer.String(), "")
+		saveSettings(w, bot)
+	}
+}
+
+// replacerTestHandler runs the enabled link replacers on the URLs of the
+// sample "text" and on the "urls" lines, without sending anything. A "regex"
+// value adds an unsaved replacer from the "name", "regex" and "format" values,
+// taking the place of the saved one with the same name.
+func replacerTestHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		candidate := telegram.Replacer{
+			Name:    r.Form.Get("name"),
+			Regex:   r.Form.Get("regex"),
+			Format:  r.Form.Get("format"),
+			Enabled: true,
+		}
+		matches, err := telegram.TryReplacers(bot, candidate, r.Form.Get("text"), strings.Split(r.Form.Get("urls"), "\n"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		links := make([]string, len(matches))
+		for i, match := range matches {
+			links[i] = match.Link
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"matches": matches, "links": links})
+	}
+}
@@ -0,0 +1,72 @@
+package webui
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// roleEntry describes an operator's role in the dashboard.
+type roleEntry struct {
+	UserID     int64         `json:"user_id,string"`
+	Role       telegram.Role `json:"role"`
+	Configured bool          `json:"configured"` // set via DASHBOARD_ADMINS, cannot be changed here
+}
+
+func rolesHandler(auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entries []roleEntry
+		for userID := range auth.admins {
+			entries = append(entries, roleEntry{UserID: userID, Role: telegram.RoleOwner, Configured: true})
+		}
+		for userID, role := range auth.cache.GetAllRoles() {
+			if auth.IsAdmin(userID) {
+				continue
+			}
+			entries = append(entries, roleEntry{UserID: userID, Role: role})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].UserID < entries[j].UserID
+		})
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+// setRoleHandler assigns a role to a user, or revokes it when role is empty.
+func setRoleHandler(bot *telegram.EscarBot, auth *Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		userID, err := strconv.ParseInt(r.Form.Get("user_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid user_id"})
+			return
+		}
+		if auth.IsAdmin(userID) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Role is set by DASHBOARD_ADMINS"})
+			return
+		}
+
+		setting := "Role " + strconv.FormatInt(userID, 10)
+		oldRole, _ := auth.cache.GetRole(userID)
+
+		roleStr := r.Form.Get("role")
+		if roleStr == "" {
+			auth.cache.DeleteRole(userID)
+			recordChange(bot, r, setting, oldRole, telegram.RoleNone)
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+			return
+		}
+
+		role, ok := telegram.ParseRole(roleStr)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid role"})
+			return
+		}
+		auth.cache.SetRole(userID, role)
+		recordChange(bot, r, setting, oldRole, role)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
@@ -0,0 +1,105 @@
+package webui
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// routeHandler adds a forwarding route, or replaces the one with the given
+// "id". The source is the "chat_id" form value.
+func routeHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		source, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid chat_id", http.StatusBadRequest)
+			return
+		}
+		target, err := strconv.ParseInt(r.Form.Get("target"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid target", http.StatusBadRequest)
+			return
+		}
+		threadID, err := parseOptionalInt(r.Form.Get("thread_id"))
+		if err != nil {
+			http.Error(w, "Invalid thread_id", http.StatusBadRequest)
+			return
+		}
+
+		pinKeep, err := parseOptionalInt(r.Form.Get("pin_keep"))
+		if err != nil {
+			http.Error(w, "Invalid pin_keep", http.StatusBadRequest)
+			return
+		}
+		pinHours, err := parseOptionalInt(r.Form.Get("pin_hours"))
+		if err != nil {
+			http.Error(w, "Invalid pin_hours", http.StatusBadRequest)
+			return
+		}
+
+		var mode bool
+		switch r.Form.Get("mode") {
+		case "", "forward":
+		case "copy":
+			mode = true
+		default:
+			http.Error(w, "mode must be \"forward\" or \"copy\"", http.StatusBadRequest)
+			return
+		}
+
+		route, old, replaced, err := telegram.SetRoute(bot, telegram.Route{
+			ID:          r.Form.Get("id"),
+			Source:      source,
+			Target:      target,
+			ThreadID:    threadID,
+			Hashtags:    splitHashtags(r.Form.Get("hashtags")),
+			MediaTypes:  r.Form["media_type"],
+			Pattern:     r.Form.Get("pattern"),
+			Copy:        mode,
+			Template:    r.Form.Get("template"),
+			Buttons:     strings.TrimSpace(r.Form.Get("buttons")),
+			Pin:         r.Form.Get("pin") == "on",
+			PinHashtags: splitHashtags(r.Form.Get("pin_hashtags")),
+			PinKeep:     pinKeep,
+			PinHours:    pinHours,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		oldValue := ""
+		if replaced {
+			oldValue = old.String()
+		}
+		recordChange(bot, r, "Route "+route.ID, oldValue, route.String())
+		if saveSettings(w, bot) {
+			writeJSON(w, http.StatusOK, route)
+		}
+	}
+}
+
+// splitHashtags splits a list of hashtags separated by commas or spaces.
+func splitHashtags(value string) []string {
+	return strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' })
+}
+
+// deleteRouteHandler removes the forwarding route with the given "id".
+func deleteRouteHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		route, ok := telegram.DeleteRoute(bot, r.Form.Get("id"))
+		if !ok {
+			http.Error(w, "Unknown route", http.StatusNotFound)
+			return
+		}
+
+		recordChange(bot, r, "Route "+route.ID, route.String(), "")
+		saveSettings(w, bot)
+	}
+}
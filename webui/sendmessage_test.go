@@ -0,0 +1,176 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/OvyFlash/telegram-bot-api"
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// newFakeBotAPI returns a BotAPI talking to a local server that answers getMe
+// and delegates every other method to handle.
+func newFakeBotAPI(t *testing.T, handle func(method string, form url.Values) (bool, string)) *tgbotapi.BotAPI {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Header().Set("Content-Type", "application/json")
+		if method == "getMe" {
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"Test","username":"test_bot"}}`))
+			return
+		}
+		ok, result := handle(method, r.Form)
+		if ok {
+			w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+		} else {
+			w.Write([]byte(`{"ok":false,"error_code":400,"description":` + result + `}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	api, err := tgbotapi.NewBotAPIWithClient("test-token", srv.URL+"/bot%s/%s", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestSendMessageHandlerValidation(t *testing.T) {
+	bot := &telegram.EscarBot{Cache: telegram.NewCache("")}
+
+	tests := []struct {
+		name   string
+		method string
+		form   url.Values
+		status int
+	}{
+		{"GET not allowed", http.MethodGet, url.Values{"chat_id": {"1"}, "text": {"hi"}}, http.StatusMethodNotAllowed},
+		{"missing chat_id", http.MethodPost, url.Values{"text": {"hi"}}, http.StatusBadRequest},
+		{"missing text", http.MethodPost, url.Values{"chat_id": {"1"}, "text": {"  "}}, http.StatusBadRequest},
+		{"bad parse_mode", http.MethodPost, url.Values{"chat_id": {"1"}, "text": {"hi"}, "parse_mode": {"BBCode"}}, http.StatusBadRequest},
+		{"bad thread id", http.MethodPost, url.Values{"chat_id": {"1"}, "text": {"hi"}, "message_thread_id": {"x"}}, http.StatusBadRequest},
+		{"bad reply id", http.MethodPost, url.Values{"chat_id": {"1"}, "text": {"hi"}, "reply_to_message_id": {"x"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/sendMessage", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			sendMessageHandler(bot).ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("status = %d, want %d", rr.Code, tt.status)
+			}
+			var resp sendMessageResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Ok || resp.Description == "" {
+				t.Errorf("expected an error description, got %+v", resp)
+			}
+		})
+	}
+}
+
+func TestSendMessageHandlerForwardsToTelegram(t *testing.T) {
+	var got url.Values
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		if method != "sendMessage" {
+			t.Errorf("unexpected method %s", method)
+		}
+		got = form
+		if form.Get("chat_id") == "-100" {
+			return false, `"Bad Request: chat not found"`
+		}
+		return true, `{"message_id":42,"date":0,"chat":{"id":123,"type":"group"}}`
+	})
+	bot := &telegram.EscarBot{Bot: api, Cache: telegram.NewCache("")}
+
+	form := url.Values{
+		"chat_id":             {"123"},
+		"text":                {"hello"},
+		"parse_mode":          {"HTML"},
+		"message_thread_id":   {"7"},
+		"reply_to_message_id": {"9"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/sendMessage", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	sendMessageHandler(bot).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var resp sendMessageResponse
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if !resp.Ok || resp.MessageID != 42 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if got.Get("text") != "hello" || got.Get("parse_mode") != "HTML" || got.Get("message_thread_id") != "7" {
+		t.Errorf("unexpected params sent to Telegram: %v", got)
+	}
+	if !strings.Contains(got.Get("reply_parameters"), `"message_id":9`) {
+		t.Errorf("reply_parameters = %q, want message_id 9", got.Get("reply_parameters"))
+	}
+
+	form.Set("chat_id", "-100")
+	req = httptest.NewRequest(http.MethodPost, "/api/sendMessage", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	sendMessageHandler(bot).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusBadGateway)
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Ok || resp.Description != "Bad Request: chat not found" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestModerationHandlersAudit(t *testing.T) {
+	api := newFakeBotAPI(t, func(method string, form url.Values) (bool, string) {
+		return true, `true`
+	})
+	cache := telegram.NewCache("")
+	bot := &telegram.EscarBot{Bot: api, Cache: cache}
+	cache.QueuePost(telegram.QueuedPost{Messages: []*tgbotapi.Message{{MessageID: 3, Chat: tgbotapi.Chat{ID: -100}}}})
+
+	for _, tt := range []struct {
+		handler http.HandlerFunc
+		form    url.Values
+	}{
+		{banUserHandler(bot), url.Values{"chat_id": {"100"}, "user_id": {"5"}, "name": {"Spammer"}}},
+		{deletePostHandler(bot), url.Values{"chat_id": {"100"}, "message_id": {"7"}}},
+		{queuedPostHandler(bot), url.Values{"chat_id": {"-100"}, "message_id": {"3"}, "action": {"cancel"}}},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%v: status = %d: %s", tt.form, rr.Code, rr.Body.String())
+		}
+	}
+
+	events := cache.GetAuditEvents(10)
+	if len(events) != 3 {
+		t.Fatalf("audit events = %+v", events)
+	}
+	var settings []string
+	for _, event := range events {
+		settings = append(settings, event.Setting+" = "+event.NewValue)
+	}
+	for _, want := range []string{"Ban in chat 100 = Spammer [5]", "Message 7 of chat 100 = deleted", "Queued post 3 of chat -100 = cancelled"} {
+		if !slices.Contains(settings, want) {
+			t.Errorf("audit events %q lack %q", settings, want)
+		}
+	}
+}
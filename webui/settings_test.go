@@ -0,0 +1,332 @@
+package webui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+func TestSettingHandlersRejectBadInput(t *testing.T) {
+	bot := &telegram.EscarBot{
+		AutoBan:          true,
+		GroupID:          -100,
+		ChannelID:        -300,
+		CaptchaTimeout:   120,
+		EnabledReplacers: map[string]bool{},
+		Groups:           map[int64]telegram.GroupSettings{-200: {}},
+		Channels:         map[int64]telegram.ChannelSettings{-400: {}},
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		form    url.Values
+	}{
+		{"toggle missing", autoBanHandler(bot), url.Values{}},
+		{"toggle garbage", autoBanHandler(bot), url.Values{"toggle": {"yes"}}},
+		{"chat id not a number", groupHandler(bot), url.Values{"id": {"abc"}}},
+		{"main group already managed", groupHandler(bot), url.Values{"id": {"-200"}}},
+		{"main channel already managed", channelHandler(bot), url.Values{"id": {"-400"}}},
+		{"captcha timeout not a number", captchaConfigHandler(bot), url.Values{"timeout": {"x"}, "maxRetries": {"3"}}},
+		{"captcha timeout zero", captchaConfigHandler(bot), url.Values{"timeout": {"0"}, "maxRetries": {"3"}}},
+		{"captcha retries negative", captchaConfigHandler(bot), url.Values{"timeout": {"60"}, "maxRetries": {"-1"}}},
+		{"replacer unknown", replacerHandler(bot), url.Values{"name": {"nope"}, "toggle": {"on"}}},
+		{"link mode unknown", linkModeHandler(bot), url.Values{"mode": {"shout"}}},
+		{"resolver host with a path", linkResolverHandler(bot), url.Values{"enabled": {"on"}, "hosts": {"t.co/x"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	if !bot.AutoBan || bot.GroupID != -100 || bot.ChannelID != -300 || bot.CaptchaTimeout != 120 || len(bot.EnabledReplacers) != 0 || bot.LinkResolver {
+		t.Errorf("bad input changed settings: %+v", bot)
+	}
+}
+
+func TestSettingHandlersPersist(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, AutoBan: true}
+
+	req := httptest.NewRequest(http.MethodPost, "/setAutoBan", strings.NewReader("toggle=off"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	autoBanHandler(bot).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %q", rr.Code, rr.Body.String())
+	}
+
+	stored := telegram.Settings{AutoBan: true}
+	if found, err := store.Load(&stored); !found || err != nil {
+		t.Fatalf("Load() = %v, %v", found, err)
+	}
+	if stored.AutoBan {
+		t.Errorf("stored AutoBan = true, want false")
+	}
+	if events := cache.GetAuditEvents(10); len(events) != 1 || events[0].Setting != "AutoBan" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestImportSettingsHandler(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, AutoBan: true, CaptchaTimeout: 120}
+	telegram.SaveSettings(bot)
+	original := cache.GetSnapshots(1)[0]
+
+	post := func(form url.Values) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/settings/import", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		importSettingsHandler(bot).ServeHTTP(rr, req)
+		return rr.Code, rr.Body.String()
+	}
+
+	doc := `{"version": 1, "settings": {"auto_ban": false, "group_id": "-42"}}`
+
+	code, body := post(url.Values{"document": {doc}, "preview": {"1"}})
+	if code != http.StatusOK || !strings.Contains(body, `"setting":"AutoBan"`) {
+		t.Errorf("preview: status = %d, body = %s", code, body)
+	}
+	if !bot.AutoBan {
+		t.Fatalf("preview applied the settings")
+	}
+
+	if code, body := post(url.Values{"document": {`{"version": 9}`}}); code != http.StatusBadRequest {
+		t.Errorf("bad version: status = %d, body = %s", code, body)
+	}
+
+	if code, body := post(url.Values{"document": {doc}}); code != http.StatusOK {
+		t.Fatalf("import: status = %d, body = %s", code, body)
+	}
+	if bot.AutoBan || bot.GroupID != -42 || bot.CaptchaTimeout != 120 {
+		t.Errorf("import: AutoBan = %v, GroupID = %d, CaptchaTimeout = %d", bot.AutoBan, bot.GroupID, bot.CaptchaTimeout)
+	}
+	if events := cache.GetAuditEvents(10); len(events) != 2 {
+		t.Errorf("import: audit events = %+v", events)
+	}
+
+	if code, body := post(url.Values{"id": {original.ID}}); code != http.StatusOK {
+		t.Fatalf("rollback: status = %d, body = %s", code, body)
+	}
+	if !bot.AutoBan || bot.GroupID != 0 {
+		t.Errorf("rollback: AutoBan = %v, GroupID = %d", bot.AutoBan, bot.GroupID)
+	}
+	if code, _ := post(url.Values{"id": {"nope"}}); code != http.StatusBadRequest {
+		t.Errorf("unknown snapshot: status = %d", code)
+	}
+}
+
+func TestGroupSettingHandlers(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, GroupID: -100, ChannelID: -200, AutoBan: true, CaptchaTimeout: 120}
+
+	post := func(handler http.HandlerFunc, form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := post(autoBanHandler(bot), url.Values{"toggle": {"off"}, "chat_id": {"-101"}}); code != http.StatusNotFound {
+		t.Errorf("unmanaged group: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := post(managedGroupHandler(bot), url.Values{"id": {"-101"}, "toggle": {"on"}}); code != http.StatusOK {
+		t.Fatalf("add group: status = %d", code)
+	}
+	if code := post(autoBanHandler(bot), url.Values{"toggle": {"off"}, "chat_id": {"-101"}}); code != http.StatusOK {
+		t.Fatalf("toggle group: status = %d", code)
+	}
+	if group, _ := telegram.GetGroupSettings(bot, -101); group.AutoBan || !bot.AutoBan {
+		t.Errorf("AutoBan: group -101 = %v, main group = %v, want false, true", group.AutoBan, bot.AutoBan)
+	}
+	if code := post(bannedWordsHandler(bot), url.Values{"word": {" spam ", ""}, "chat_id": {"-101"}}); code != http.StatusNoContent {
+		t.Errorf("banned words: status = %d, want %d", code, http.StatusNoContent)
+	}
+	if group, _ := telegram.GetGroupSettings(bot, -101); len(group.BannedWords) != 1 || group.BannedWords[0] != "spam" {
+		t.Errorf("BannedWords: group -101 = %q", group.BannedWords)
+	}
+
+	if code := post(managedChannelHandler(bot), url.Values{"id": {"-201"}, "toggle": {"on"}}); code != http.StatusOK {
+		t.Fatalf("add channel: status = %d", code)
+	}
+	route := url.Values{"id": {"r1"}, "chat_id": {"-201"}, "target": {"-101"}, "thread_id": {"5"}, "hashtags": {"#news, music"}, "media_type": {"photo", "video"}, "mode": {"copy"}}
+	if code := post(routeHandler(bot), route); code != http.StatusOK {
+		t.Fatalf("add route: status = %d", code)
+	}
+	if routes := telegram.GetRoutes(bot, -201); len(routes) != 1 || !routes[0].Copy || len(routes[0].Hashtags) != 2 || len(routes[0].MediaTypes) != 2 {
+		t.Errorf("channel -201 routes = %+v", routes)
+	}
+	if code := post(routeHandler(bot), url.Values{"chat_id": {"-201"}, "target": {"-101"}, "pattern": {"("}}); code != http.StatusBadRequest {
+		t.Errorf("bad pattern: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := post(deleteRouteHandler(bot), url.Values{"id": {"nope"}}); code != http.StatusNotFound {
+		t.Errorf("unknown route: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	var settings []string
+	for _, event := range cache.GetAuditEvents(10) {
+		settings = append(settings, event.Setting)
+	}
+	want := "Route r1,Channel -201,Group -101 BannedWords,Group -101 AutoBan,Group -101"
+	if got := strings.Join(settings, ","); got != want {
+		t.Errorf("audit settings = %q, want %q", got, want)
+	}
+
+	stored := telegram.Settings{}
+	if found, err := store.Load(&stored); !found || err != nil || len(stored.Groups) != 1 || len(stored.Channels) != 1 || len(stored.Routes) != 1 {
+		t.Errorf("stored settings = %+v, %v, %v", stored, found, err)
+	}
+}
+
+func TestPostDeletePolicyHandler(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, PostDeletePolicy: telegram.PostDeleteKeep}
+
+	for _, tt := range []struct {
+		policy string
+		code   int
+		want   string
+	}{
+		{"delete", http.StatusOK, telegram.PostDeleteDelete},
+		{"shred", http.StatusBadRequest, telegram.PostDeleteDelete},
+	} {
+		form := url.Values{"policy": {tt.policy}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		postDeletePolicyHandler(bot).ServeHTTP(rr, req)
+		if rr.Code != tt.code || bot.PostDeletePolicy != tt.want {
+			t.Errorf("%s: status = %d, policy = %q, want %d, %q", tt.policy, rr.Code, bot.PostDeletePolicy, tt.code, tt.want)
+		}
+	}
+	if events := cache.GetAuditEvents(10); len(events) != 1 || events[0].Setting != "PostDeletePolicy" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestDeliveryHandler(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, Timezone: "UTC"}
+
+	for _, tt := range []struct {
+		quietHours, timezone string
+		code                 int
+	}{
+		{"23:00-08:00", "Europe/Rome", http.StatusOK},
+		{"late", "Europe/Rome", http.StatusBadRequest},
+		{"", "Mars/Olympus", http.StatusBadRequest},
+	} {
+		form := url.Values{"quiet_hours": {tt.quietHours}, "timezone": {tt.timezone}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		deliveryHandler(bot).ServeHTTP(rr, req)
+		if rr.Code != tt.code {
+			t.Errorf("%q %q: status = %d, want %d", tt.quietHours, tt.timezone, rr.Code, tt.code)
+		}
+	}
+	if bot.QuietHours != "23:00-08:00" || bot.Timezone != "Europe/Rome" {
+		t.Errorf("QuietHours = %q, Timezone = %q", bot.QuietHours, bot.Timezone)
+	}
+	if events := cache.GetAuditEvents(10); len(events) != 2 {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestReplacerHandlers(t *testing.T) {
+	cache := telegram.NewCache("")
+	store := telegram.NewSettingsStore(cache, filepath.Join(t.TempDir(), "settings.json"))
+	bot := &telegram.EscarBot{Cache: cache, SettingsStore: store, Replacers: telegram.DefaultReplacers()}
+
+	post := func(handler http.HandlerFunc, form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	threads := url.Values{"name": {"Threads"}, "regex": {`threads\.net/(@\w+)`}, "format": {"https://fixthreads.net/%s"}, "enabled": {"on"}}
+	if code := post(saveReplacerHandler(bot), threads); code != http.StatusOK {
+		t.Fatalf("add replacer: status = %d", code)
+	}
+	if code := post(saveReplacerHandler(bot), url.Values{"name": {"Bad"}, "regex": {"("}, "format": {"https://a/%s"}}); code != http.StatusBadRequest {
+		t.Errorf("bad regex: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := post(deleteReplacerHandler(bot), url.Values{"name": {"Twitter"}}); code != http.StatusOK {
+		t.Fatalf("delete replacer: status = %d", code)
+	}
+	if code := post(deleteReplacerHandler(bot), url.Values{"name": {"Twitter"}}); code != http.StatusNotFound {
+		t.Errorf("unknown replacer: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	stored := telegram.Settings{}
+	if found, err := store.Load(&stored); !found || err != nil || len(stored.Replacers) != len(telegram.DefaultReplacers()) {
+		t.Errorf("stored replacers = %+v, %v, %v", stored.Replacers, found, err)
+	}
+	if events := cache.GetAuditEvents(10); len(events) != 2 || events[0].Setting != "Replacer rule Twitter" || events[1].Setting != "Replacer rule Threads" {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestReplacerTestHandler(t *testing.T) {
+	bot := &telegram.EscarBot{Cache: telegram.NewCache(""), Replacers: telegram.DefaultReplacers()}
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantLinks  []string
+	}{
+		{"saved replacers", url.Values{"text": {"https://twitter.com/jack/status/20"}}, http.StatusOK, []string{"https://fxtwitter.com/jack/status/20"}},
+		{"no match", url.Values{"urls": {"https://example.com"}}, http.StatusOK, []string{}},
+		{"unsaved replacer", url.Values{"urls": {"https://threads.net/@zuck"}, "name": {"Threads"}, "regex": {`threads\.net/(@\w+)`}, "format": {"https://fixthreads.net/%s"}}, http.StatusOK, []string{"https://fixthreads.net/@zuck"}},
+		{"invalid replacer", url.Values{"regex": {`a(`}, "format": {"https://a/%s"}}, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/replacers/test", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			replacerTestHandler(bot).ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Links []string `json:"links"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || !reflect.DeepEqual(resp.Links, tt.wantLinks) {
+				t.Errorf("links = %v, %v, want %v", resp.Links, err, tt.wantLinks)
+			}
+		})
+	}
+	if len(telegram.GetReplacers(bot)) != len(telegram.DefaultReplacers()) {
+		t.Errorf("the sandbox changed the saved replacers")
+	}
+}
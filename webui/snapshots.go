@@ -0,0 +1,82 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/birabittoh/escarbot/telegram"
+)
+
+// snapshotsLimit is the number of snapshots listed in the dashboard.
+const snapshotsLimit = 50
+
+// exportSettingsHandler downloads the current settings as a JSON document.
+func exportSettingsHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot := telegram.NewSnapshot(telegram.GetSettings(bot))
+		snapshot.ID = ""
+		filename := fmt.Sprintf("escarbot-settings-%s.json", snapshot.CreatedAt.Format("2006-01-02-150405"))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
+
+// snapshotsHandler lists the most recent settings snapshots.
+func snapshotsHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshots := bot.Cache.GetSnapshots(snapshotsLimit)
+		if snapshots == nil {
+			snapshots = []telegram.SettingsSnapshot{}
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	}
+}
+
+// importSettingsHandler replaces the settings with an uploaded document, or
+// with a past snapshot when "id" is set. With preview=1 it only returns the
+// changes that would be applied.
+func importSettingsHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		current := telegram.GetSettings(bot)
+
+		document := []byte(r.Form.Get("document"))
+		if id := r.Form.Get("id"); id != "" {
+			snapshot, ok := bot.Cache.GetSnapshot(id)
+			if !ok {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown snapshot"})
+				return
+			}
+			// Go through the document format too, so replacers added since
+			// the snapshot was taken keep their current state.
+			document, _ = json.Marshal(snapshot)
+		}
+
+		snapshot, err := telegram.ParseSnapshot(document, current)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		settings := snapshot.Settings
+
+		changes := telegram.DiffSettings(current, settings)
+		if preview, _ := strconv.ParseBool(r.Form.Get("preview")); preview || len(changes) == 0 {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"changes": changes})
+			return
+		}
+
+		changes = telegram.ReplaceSettings(bot, settings)
+		for _, change := range changes {
+			recordChange(bot, r, change.Setting, change.OldValue, change.NewValue)
+		}
+		if err := telegram.SaveSettings(bot); err != nil {
+			log.Printf("Error saving settings: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Error saving settings"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"changes": changes})
+	}
+}
@@ -9,16 +9,14 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for local development
-	},
-}
+// upgrader uses the default same-origin check, so other sites cannot open a
+// WebSocket with the operator's session cookie.
+var upgrader = websocket.Upgrader{}
 
 // MessageHub manages WebSocket connections and broadcasts messages
 type MessageHub struct {
 	clients    map[*websocket.Conn]bool
-	broadcast  chan telegram.CachedMessage
+	broadcast  chan interface{}
 	register   chan *websocket.Conn
 	unregister chan *websocket.Conn
 	mu         sync.Mutex
@@ -31,7 +29,7 @@ var hub *MessageHub
 func InitMessageHub() {
 	hub = &MessageHub{
 		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan telegram.CachedMessage, 100),
+		broadcast:  make(chan interface{}, 100),
 		register:   make(chan *websocket.Conn),
 		unregister: make(chan *websocket.Conn),
 	}
@@ -73,11 +71,27 @@ func (h *MessageHub) run() {
 	}
 }
 
+// Event is pushed to dashboards alongside cached messages, which it can be
+// told apart from by the event field.
+type Event struct {
+	Event    string   `json:"event"`
+	Settings []string `json:"settings,omitempty"`
+}
+
 // BroadcastMessage sends a message to all connected clients
 func BroadcastMessage(msg telegram.CachedMessage) {
+	broadcast(msg)
+}
+
+// BroadcastEvent sends an event to all connected clients
+func BroadcastEvent(event Event) {
+	broadcast(event)
+}
+
+func broadcast(v interface{}) {
 	if hub != nil {
 		select {
-		case hub.broadcast <- msg:
+		case hub.broadcast <- v:
 		default:
 			log.Println("Broadcast channel full, dropping message")
 		}
@@ -3,18 +3,24 @@ package webui
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	tgbotapi "github.com/OvyFlash/telegram-bot-api"
 	"github.com/birabittoh/escarbot/telegram"
 )
 
+// historyLimit is the number of audit events shown in the History panel.
+const historyLimit = 100
+
 type WebUI struct {
 	Server   *http.ServeMux
 	EscarBot *telegram.EscarBot
@@ -22,27 +28,84 @@ type WebUI struct {
 	port string
 }
 
-var indexTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
-	"toJSON": func(v interface{}) (string, error) {
-		bytes, err := json.Marshal(v)
-		if err != nil {
-			return "", err
-		}
-		return string(bytes), nil
-	},
-}).ParseFiles("index.html"))
+var (
+	indexTemplate *template.Template
+	loginTemplate *template.Template
+)
+
+// loadTemplate parses the named HTML file from the working directory.
+func loadTemplate(name string) *template.Template {
+	return template.Must(template.New(name).Funcs(template.FuncMap{
+		"toJSON": func(v interface{}) (string, error) {
+			bytes, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return string(bytes), nil
+		},
+	}).ParseFiles(name))
+}
 
 func indexHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// The "chat" query parameter picks the managed chat whose settings are shown.
+		chatID, _ := strconv.ParseInt(r.URL.Query().Get("chat"), 10, 64)
+		group, isGroup := telegram.GetGroupSettings(bot, chatID)
+		channel, isChannel := telegram.GetChannelSettings(bot, chatID)
+		if !isGroup && !isChannel {
+			chatID = 0
+		}
+		chats := managedChats(bot)
+		var routes []telegram.Route
+		var queue []telegram.QueuedPost
+		if isChannel && !isGroup {
+			routes = telegram.GetRoutes(bot, chatID)
+			for _, post := range bot.Cache.GetQueuedPosts() {
+				if post.ChatID() == chatID {
+					queue = append(queue, post)
+				}
+			}
+		}
+		tickets := telegram.OpenTickets(bot)
+		replacers := telegram.GetReplacers(bot)
+
 		bot.StateMutex.RLock()
 		defer bot.StateMutex.RUnlock()
 
+		if chatID == 0 {
+			chatID = bot.GroupID
+		}
+		operator, _ := operatorFromRequest(r)
 		data := struct {
 			*telegram.EscarBot
-			AllReplacers []telegram.Replacer
+			AllReplacers   []telegram.Replacer
+			Operator       Operator
+			ManagedChats   []managedChat
+			SelectedChat   int64
+			IsChannel      bool
+			IsMainChannel  bool
+			Group          telegram.GroupSettings
+			Channel        telegram.ChannelSettings
+			Routes         []telegram.Route
+			MediaTypes     []string
+			DeletePolicies []string
+			Tickets        []telegram.Ticket
+			Queue          []telegram.QueuedPost
 		}{
 			bot,
-			telegram.GetReplacers(),
+			replacers,
+			operator,
+			chats,
+			chatID,
+			isChannel && !isGroup,
+			chatID == bot.ChannelID,
+			group,
+			channel,
+			routes,
+			telegram.RouteMediaTypes,
+			telegram.PostDeletePolicies,
+			tickets,
+			queue,
 		}
 		buf := &bytes.Buffer{}
 		err := indexTemplate.Execute(buf, data)
@@ -54,10 +117,16 @@ func indexHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	}
 }
 
-func toggleBotProperty(r *http.Request) bool {
+// getToggle parses the "toggle" form value, which must be "on" or "off".
+func getToggle(r *http.Request) (bool, error) {
 	r.ParseForm()
-	res := r.Form.Get("toggle")
-	return res == "on"
+	switch r.Form.Get("toggle") {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, errors.New("toggle must be \"on\" or \"off\"")
 }
 
 func getChatID(r *http.Request) (int64, error) {
@@ -66,51 +135,117 @@ func getChatID(r *http.Request) (int64, error) {
 	return strconv.ParseInt(res, 10, 64)
 }
 
-func linksHandler(bot *telegram.EscarBot) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.LinkDetection = toggleBotProperty(r)
-		UpdateBoolEnvVar("LINK_DETECTION", bot.LinkDetection)
+// recordChange writes an audit event for a setting changed by the operator of
+// r. Calls that don't change anything are ignored.
+func recordChange(bot *telegram.EscarBot, r *http.Request, setting string, oldValue, newValue interface{}) {
+	oldStr, newStr := fmt.Sprint(oldValue), fmt.Sprint(newValue)
+	if oldStr == newStr {
+		return
 	}
+	op, _ := operatorFromRequest(r)
+	telegram.RecordAudit(bot, telegram.AuditEvent{
+		OperatorID:   op.ID,
+		OperatorName: op.Name,
+		Setting:      setting,
+		OldValue:     oldStr,
+		NewValue:     newStr,
+	})
 }
 
-func channelForwardHandler(bot *telegram.EscarBot) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.ChannelForward = toggleBotProperty(r)
-		UpdateBoolEnvVar("CHANNEL_FORWARD", bot.ChannelForward)
+// saveSettings persists the bot settings, replying with a 500 if that fails.
+func saveSettings(w http.ResponseWriter, bot *telegram.EscarBot) bool {
+	if err := telegram.SaveSettings(bot); err != nil {
+		log.Printf("Error saving settings: %v", err)
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return false
 	}
+	return true
 }
 
-func adminForwardHandler(bot *telegram.EscarBot) http.HandlerFunc {
+// toggleHandler sets the bool field returned by field from the "toggle" form
+// value.
+func toggleHandler(bot *telegram.EscarBot, setting string, field func(*telegram.EscarBot) *bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := getToggle(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.AdminForward = toggleBotProperty(r)
-		UpdateBoolEnvVar("ADMIN_FORWARD", bot.AdminForward)
+		value := field(bot)
+		old := *value
+		*value = enabled
+		bot.StateMutex.Unlock()
+
+		recordChange(bot, r, setting, old, enabled)
+		saveSettings(w, bot)
 	}
 }
 
-func autoBanHandler(bot *telegram.EscarBot) http.HandlerFunc {
+func linksHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return groupToggleHandler(bot, func(g *telegram.GroupSettings) *bool { return &g.LinkDetection })
+}
+
+// linkModeHandler sets how link detection shows the fixed links in a group
+// from the "mode" form value.
+func linkModeHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.AutoBan = toggleBotProperty(r)
-		UpdateBoolEnvVar("AUTO_BAN", bot.AutoBan)
+		r.ParseForm()
+		mode := r.Form.Get("mode")
+		if !slices.Contains(telegram.LinkModes, mode) {
+			http.Error(w, "Unknown mode", http.StatusBadRequest)
+			return
+		}
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) { g.LinkMode = mode })
 	}
 }
 
-func captchaHandler(bot *telegram.EscarBot) http.HandlerFunc {
+// linkResolverHandler turns the short link resolver on or off from the
+// "enabled" form value and sets the hosts it follows from the comma or newline
+// separated "hosts" value.
+func linkResolverHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		enabled := r.Form.Get("enabled") == "on"
+		hosts := telegram.NormalizeHosts(strings.FieldsFunc(r.Form.Get("hosts"), func(c rune) bool { return c == ',' || c == '\n' }))
+		for _, host := range hosts {
+			if err := telegram.ValidateHost(host); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
 		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.Captcha = toggleBotProperty(r)
-		UpdateBoolEnvVar("CAPTCHA", bot.Captcha)
+		oldEnabled, oldHosts := bot.LinkResolver, bot.ResolverHosts
+		bot.LinkResolver, bot.ResolverHosts = enabled, hosts
+		bot.StateMutex.Unlock()
+
+		recordChange(bot, r, "LinkResolver", oldEnabled, enabled)
+		recordChange(bot, r, "ResolverHosts", strings.Join(oldHosts, ","), strings.Join(hosts, ","))
+		saveSettings(w, bot)
 	}
 }
 
+func adminForwardHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return toggleHandler(bot, "AdminForward", func(b *telegram.EscarBot) *bool { return &b.AdminForward })
+}
+
+func autoBanHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return groupToggleHandler(bot, func(g *telegram.GroupSettings) *bool { return &g.AutoBan })
+}
+
+func captchaHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return groupToggleHandler(bot, func(g *telegram.GroupSettings) *bool { return &g.Captcha })
+}
+
+func welcomeMessageHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return groupToggleHandler(bot, func(g *telegram.GroupSettings) *bool { return &g.WelcomeMessage })
+}
+
+func auditLogHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return toggleHandler(bot, "AuditLog", func(b *telegram.EscarBot) *bool { return &b.AuditLog })
+}
+
 func captchaConfigHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
@@ -119,27 +254,22 @@ func captchaConfigHandler(bot *telegram.EscarBot) http.HandlerFunc {
 		maxRetriesStr := r.Form.Get("maxRetries")
 		captchaText := r.Form.Get("captchaText")
 
-		bot.StateMutex.Lock()
-		if val, err := strconv.Atoi(timeoutStr); err == nil {
-			bot.CaptchaTimeout = val
-			UpdateEnvVar("CAPTCHA_TIMEOUT", timeoutStr)
+		timeout, err := strconv.Atoi(timeoutStr)
+		if err != nil || timeout <= 0 {
+			http.Error(w, "Invalid timeout", http.StatusBadRequest)
+			return
 		}
-		if val, err := strconv.Atoi(maxRetriesStr); err == nil {
-			bot.CaptchaMaxRetries = val
-			UpdateEnvVar("CAPTCHA_MAX_RETRIES", maxRetriesStr)
+		maxRetries, err := strconv.Atoi(maxRetriesStr)
+		if err != nil || maxRetries < 0 {
+			http.Error(w, "Invalid maxRetries", http.StatusBadRequest)
+			return
 		}
-		bot.CaptchaText = captchaText
-		UpdateEnvVar("CAPTCHA_TEXT", captchaText)
-		bot.StateMutex.Unlock()
-	}
-}
 
-func welcomeMessageHandler(bot *telegram.EscarBot) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		bot.StateMutex.Lock()
-		defer bot.StateMutex.Unlock()
-		bot.WelcomeMessage = toggleBotProperty(r)
-		UpdateBoolEnvVar("WELCOME_MESSAGE", bot.WelcomeMessage)
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) {
+			g.CaptchaTimeout = timeout
+			g.CaptchaMaxRetries = maxRetries
+			g.CaptchaText = captchaText
+		})
 	}
 }
 
@@ -151,79 +281,103 @@ func welcomeContentHandler(bot *telegram.EscarBot) http.HandlerFunc {
 		welcomePhoto := r.Form.Get("welcomePhoto")
 		welcomeLinks := r.Form.Get("welcomeLinks")
 
-		bot.StateMutex.Lock()
-		bot.WelcomeText = welcomeText
-		bot.WelcomePhoto = welcomePhoto
-		bot.WelcomeLinks = welcomeLinks
-		bot.StateMutex.Unlock()
-
-		UpdateEnvVar("WELCOME_TEXT", welcomeText)
-		UpdateEnvVar("WELCOME_PHOTO", welcomePhoto)
-		UpdateEnvVar("WELCOME_LINKS", welcomeLinks)
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) {
+			g.WelcomeText = welcomeText
+			g.WelcomePhoto = welcomePhoto
+			g.WelcomeLinks = welcomeLinks
+		})
+	}
+}
 
-		log.Printf("Welcome content updated: text=%d chars, photo=%s, links=%d chars",
-			len(welcomeText), welcomePhoto, len(welcomeLinks))
+// rulesHandler sets the rules text of the group picked by the "chat_id" form
+// value from the "rulesText" form value.
+func rulesHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rulesText := r.Form.Get("rulesText")
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) { g.RulesText = rulesText })
 	}
 }
 
-func channelHandler(bot *telegram.EscarBot) http.HandlerFunc {
+// startTextHandler sets the /start greeting from the "startText" form value.
+func startTextHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		res, err := getChatID(r)
-		if err != nil {
-			log.Println(err)
-			return
-		}
+		r.ParseForm()
+		startText := r.Form.Get("startText")
+
 		bot.StateMutex.Lock()
-		bot.ChannelID = res
+		old := bot.StartText
+		bot.StartText = startText
 		bot.StateMutex.Unlock()
-		UpdateEnvVar("CHANNEL_ID", strconv.FormatInt(res, 10))
+
+		recordChange(bot, r, "StartText", old, startText)
+		saveSettings(w, bot)
 	}
 }
 
-func groupHandler(bot *telegram.EscarBot) http.HandlerFunc {
+// chatIDHandler sets the chat ID returned by field from the "id" form value.
+// taken, if not nil, reports the IDs the setting cannot have because another
+// setting holds them, as Settings.Validate would.
+func chatIDHandler(bot *telegram.EscarBot, setting string, field func(*telegram.EscarBot) *int64, taken func(*telegram.EscarBot, int64) bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		res, err := getChatID(r)
 		if err != nil {
-			log.Println(err)
+			http.Error(w, "Invalid id", http.StatusBadRequest)
 			return
 		}
 		bot.StateMutex.Lock()
-		bot.GroupID = res
+		if taken != nil && taken(bot, res) {
+			bot.StateMutex.Unlock()
+			http.Error(w, fmt.Sprintf("Chat %d is already managed as an additional chat", res), http.StatusBadRequest)
+			return
+		}
+		value := field(bot)
+		old := *value
+		*value = res
 		bot.StateMutex.Unlock()
-		UpdateEnvVar("GROUP_ID", strconv.FormatInt(res, 10))
+
+		recordChange(bot, r, setting, old, res)
+		saveSettings(w, bot)
 	}
 }
 
+func channelHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return chatIDHandler(bot, "ChannelID", func(b *telegram.EscarBot) *int64 { return &b.ChannelID },
+		func(b *telegram.EscarBot, id int64) bool { _, managed := b.Channels[id]; return managed })
+}
+
+func groupHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return chatIDHandler(bot, "GroupID", func(b *telegram.EscarBot) *int64 { return &b.GroupID },
+		func(b *telegram.EscarBot, id int64) bool { _, managed := b.Groups[id]; return managed })
+}
+
 func adminHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return chatIDHandler(bot, "AdminID", func(b *telegram.EscarBot) *int64 { return &b.AdminID }, nil)
+}
+
+func modmailGroupHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return chatIDHandler(bot, "ModmailGroupID", func(b *telegram.EscarBot) *int64 { return &b.ModmailGroupID }, nil)
+}
+
+func replacerHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		res, err := getChatID(r)
+		enabled, err := getToggle(r)
 		if err != nil {
-			log.Println(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		bot.StateMutex.Lock()
-		bot.AdminID = res
-		bot.StateMutex.Unlock()
-		UpdateEnvVar("ADMIN_ID", strconv.FormatInt(res, 10))
-	}
-}
 
-func replacerHandler(bot *telegram.EscarBot) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		r.ParseForm()
 		name := r.Form.Get("name")
-		enabled := r.Form.Get("toggle") == "on"
-
 		if name == "" {
 			http.Error(w, "Missing name", http.StatusBadRequest)
 			return
 		}
+		if !telegram.IsReplacer(bot, name) {
+			http.Error(w, "Unknown replacer", http.StatusBadRequest)
+			return
+		}
 
-		bot.StateMutex.Lock()
-		bot.EnabledReplacers[name] = enabled
-		bot.StateMutex.Unlock()
-		envKey := "REPLACER_" + strings.ReplaceAll(strings.ToUpper(name), " ", "_") + "_ENABLED"
-		UpdateBoolEnvVar(envKey, enabled)
+		updateGroup(w, r, bot, func(g *telegram.GroupSettings) { g.EnabledReplacers[name] = enabled })
 	}
 }
 
@@ -241,16 +395,20 @@ func bannedWordsHandler(bot *telegram.EscarBot) http.HandlerFunc {
 			}
 		}
 
-		bot.StateMutex.Lock()
-		bot.BannedWords = filteredWords
-		bot.StateMutex.Unlock()
-
-		wordsStr := strings.Join(filteredWords, ",")
-		UpdateEnvVar("BANNED_WORDS", wordsStr)
-
-		log.Printf("Banned words updated: %v", filteredWords)
+		if updateGroup(w, r, bot, func(g *telegram.GroupSettings) { g.BannedWords = filteredWords }) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
 
-		http.Redirect(w, r, "/", http.StatusFound)
+// historyHandler returns the most recent configuration changes.
+func historyHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events := bot.Cache.GetAuditEvents(historyLimit)
+		if events == nil {
+			events = []telegram.AuditEvent{}
+		}
+		writeJSON(w, http.StatusOK, events)
 	}
 }
 
@@ -345,6 +503,240 @@ func setReactionHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	}
 }
 
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// sendMessageResponse mirrors the shape of Telegram's own API responses so the
+// dashboard can handle both the same way.
+type sendMessageResponse struct {
+	Ok          bool   `json:"ok"`
+	MessageID   int    `json:"message_id,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var allowedParseModes = map[string]bool{
+	"":                      true,
+	tgbotapi.ModeMarkdown:   true,
+	tgbotapi.ModeMarkdownV2: true,
+	tgbotapi.ModeHTML:       true,
+}
+
+// parseOptionalInt parses an optional integer form value, returning 0 when
+// the value is empty.
+func parseOptionalInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// sendMessageHandler sends a message through the bot, so the dashboard never
+// needs to know the bot token.
+func sendMessageHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, sendMessageResponse{Description: "Method not allowed"})
+			return
+		}
+		r.ParseForm()
+
+		chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, sendMessageResponse{Description: "Invalid chat_id"})
+			return
+		}
+
+		text := r.Form.Get("text")
+		if strings.TrimSpace(text) == "" {
+			writeJSON(w, http.StatusBadRequest, sendMessageResponse{Description: "Missing text"})
+			return
+		}
+
+		parseMode := r.Form.Get("parse_mode")
+		if !allowedParseModes[parseMode] {
+			writeJSON(w, http.StatusBadRequest, sendMessageResponse{Description: "Invalid parse_mode"})
+			return
+		}
+
+		threadID, err := parseOptionalInt(r.Form.Get("message_thread_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, sendMessageResponse{Description: "Invalid message_thread_id"})
+			return
+		}
+
+		replyTo, err := parseOptionalInt(r.Form.Get("reply_to_message_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, sendMessageResponse{Description: "Invalid reply_to_message_id"})
+			return
+		}
+
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = parseMode
+		msg.MessageThreadID = threadID
+		msg.ReplyParameters.MessageID = replyTo
+
+		sent, err := bot.Bot.Send(msg)
+		if err != nil {
+			log.Printf("Error sending message to chat %d: %v", chatID, err)
+			status := http.StatusInternalServerError
+			var tgErr *tgbotapi.Error
+			if errors.As(err, &tgErr) {
+				status = http.StatusBadGateway
+			}
+			writeJSON(w, status, sendMessageResponse{Description: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, sendMessageResponse{Ok: true, MessageID: sent.MessageID})
+	}
+}
+
+// banUserHandler bans the author of a cached message.
+func banUserHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat_id"})
+			return
+		}
+
+		userID, err := strconv.ParseInt(r.Form.Get("user_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid user_id"})
+			return
+		}
+
+		user := tgbotapi.User{ID: userID, FirstName: r.Form.Get("name")}
+		if err := telegram.BanUser(bot, chatID, user); err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+
+		recordChange(bot, r, fmt.Sprintf("Ban in chat %d", chatID), "", fmt.Sprintf("%s [%d]", user.FirstName, userID))
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// postDeletePolicyHandler sets what happens to the copies of deleted channel
+// posts from the "policy" form value.
+func postDeletePolicyHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		policy := r.Form.Get("policy")
+		if !slices.Contains(telegram.PostDeletePolicies, policy) {
+			http.Error(w, "Unknown policy", http.StatusBadRequest)
+			return
+		}
+
+		bot.StateMutex.Lock()
+		old := bot.PostDeletePolicy
+		bot.PostDeletePolicy = policy
+		bot.StateMutex.Unlock()
+
+		recordChange(bot, r, "PostDeletePolicy", old, policy)
+		saveSettings(w, bot)
+	}
+}
+
+// deliveryHandler sets the quiet hours and the time zone of channel post
+// deliveries from the "quiet_hours" and "timezone" form values.
+func deliveryHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		quietHours := strings.TrimSpace(r.Form.Get("quiet_hours"))
+		if _, err := telegram.ParseQuietHours(quietHours); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		timezone := strings.TrimSpace(r.Form.Get("timezone"))
+		if _, err := time.LoadLocation(timezone); err != nil || timezone == "" {
+			http.Error(w, "Unknown time zone", http.StatusBadRequest)
+			return
+		}
+
+		bot.StateMutex.Lock()
+		oldQuietHours, oldTimezone := bot.QuietHours, bot.Timezone
+		bot.QuietHours, bot.Timezone = quietHours, timezone
+		bot.StateMutex.Unlock()
+
+		recordChange(bot, r, "QuietHours", oldQuietHours, quietHours)
+		recordChange(bot, r, "Timezone", oldTimezone, timezone)
+		saveSettings(w, bot)
+	}
+}
+
+// queuedPostHandler sends right away ("send" action) or drops ("cancel"
+// action) the queued channel post "message_id" of "chat_id".
+func queuedPostHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat_id"})
+			return
+		}
+		msgID, err := strconv.Atoi(r.Form.Get("message_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid message_id"})
+			return
+		}
+
+		var ok bool
+		var outcome string
+		switch action := r.Form.Get("action"); action {
+		case "send":
+			ok, outcome = telegram.SendQueuedPost(bot, chatID, msgID), "sent"
+		case "cancel":
+			ok, outcome = bot.Cache.DeleteQueuedPost(chatID, msgID), "cancelled"
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "action must be \"send\" or \"cancel\""})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "Post not queued"})
+			return
+		}
+
+		recordChange(bot, r, fmt.Sprintf("Queued post %d of chat %d", msgID, chatID), "queued", outcome)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// deletePostHandler deletes a message, and the copies of channel posts
+// according to the deletion policy.
+func deletePostHandler(bot *telegram.EscarBot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		chatID, err := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid chat_id"})
+			return
+		}
+		msgID, err := strconv.Atoi(r.Form.Get("message_id"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid message_id"})
+			return
+		}
+
+		if err := telegram.DeletePost(bot, chatID, msgID); err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+
+		recordChange(bot, r, fmt.Sprintf("Message %d of chat %d", msgID, chatID), "present", "deleted")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
 func mediaHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fileID := r.URL.Query().Get("file_id")
@@ -387,35 +779,83 @@ func mediaHandler(bot *telegram.EscarBot) http.HandlerFunc {
 	}
 }
 
-func NewWebUI(port string, bot *telegram.EscarBot) WebUI {
+// NewWebUI sets up the dashboard. admins are the Telegram user IDs that are
+// always allowed to log in as owners.
+func NewWebUI(port string, bot *telegram.EscarBot, admins []int64, sessionSecret string) WebUI {
+	indexTemplate = loadTemplate("index.html")
+	loginTemplate = loadTemplate("login.html")
 	InitMessageHub()
 
+	if len(admins) == 0 {
+		log.Println("Warning: no dashboard admins configured, nobody will be able to log in.")
+	}
+	auth := NewAuth(bot.Bot.Token, admins, bot.Cache, sessionSecret)
+
 	bot.OnMessageCached = func(msg telegram.CachedMessage) {
 		BroadcastMessage(msg)
 	}
+	bot.OnConfigReloaded = func(changed []string) {
+		BroadcastEvent(Event{Event: "config_reloaded", Settings: changed})
+	}
 
 	go telegram.BotPoll(bot)
 
+	viewer := func(h http.HandlerFunc) http.HandlerFunc { return requireRole(telegram.RoleViewer, h) }
+	moderator := func(h http.HandlerFunc) http.HandlerFunc { return requireRole(telegram.RoleModerator, h) }
+	owner := func(h http.HandlerFunc) http.HandlerFunc { return requireRole(telegram.RoleOwner, h) }
+	// State-changing routes only accept POST requests carrying the session's CSRF token.
+	mutating := func(h http.HandlerFunc) http.HandlerFunc { return requirePost(requireCSRF(h)) }
+
+	protected := http.NewServeMux()
+	protected.HandleFunc("/", viewer(indexHandler(bot)))
+	protected.HandleFunc("/setLinks", owner(mutating(linksHandler(bot))))
+	protected.HandleFunc("/setLinkMode", owner(mutating(linkModeHandler(bot))))
+	protected.HandleFunc("/setLinkResolver", owner(mutating(linkResolverHandler(bot))))
+	protected.HandleFunc("/setChannelForward", owner(mutating(channelForwardHandler(bot))))
+	protected.HandleFunc("/setAdminForward", owner(mutating(adminForwardHandler(bot))))
+	protected.HandleFunc("/setAutoBan", owner(mutating(autoBanHandler(bot))))
+	protected.HandleFunc("/setCaptcha", owner(mutating(captchaHandler(bot))))
+	protected.HandleFunc("/setCaptchaConfig", owner(mutating(captchaConfigHandler(bot))))
+	protected.HandleFunc("/setWelcomeMessage", owner(mutating(welcomeMessageHandler(bot))))
+	protected.HandleFunc("/setWelcomeContent", owner(mutating(welcomeContentHandler(bot))))
+	protected.HandleFunc("/setRules", owner(mutating(rulesHandler(bot))))
+	protected.HandleFunc("/setStartText", owner(mutating(startTextHandler(bot))))
+	protected.HandleFunc("/setChannel", owner(mutating(channelHandler(bot))))
+	protected.HandleFunc("/setGroup", owner(mutating(groupHandler(bot))))
+	protected.HandleFunc("/setAdmin", owner(mutating(adminHandler(bot))))
+	protected.HandleFunc("/setModmailGroup", owner(mutating(modmailGroupHandler(bot))))
+	protected.HandleFunc("/setReplacer", owner(mutating(replacerHandler(bot))))
+	protected.HandleFunc("/saveReplacer", owner(mutating(saveReplacerHandler(bot))))
+	protected.HandleFunc("/deleteReplacer", owner(mutating(deleteReplacerHandler(bot))))
+	protected.HandleFunc("/api/replacers/test", owner(mutating(replacerTestHandler(bot))))
+	protected.HandleFunc("/setRoute", owner(mutating(routeHandler(bot))))
+	protected.HandleFunc("/deleteRoute", owner(mutating(deleteRouteHandler(bot))))
+	protected.HandleFunc("/setPostDeletePolicy", owner(mutating(postDeletePolicyHandler(bot))))
+	protected.HandleFunc("/setDelivery", owner(mutating(deliveryHandler(bot))))
+	protected.HandleFunc("/setManagedGroup", owner(mutating(managedGroupHandler(bot))))
+	protected.HandleFunc("/setManagedChannel", owner(mutating(managedChannelHandler(bot))))
+	protected.HandleFunc("/setBannedWords", owner(mutating(bannedWordsHandler(bot))))
+	protected.HandleFunc("/setAuditLog", owner(mutating(auditLogHandler(bot))))
+	protected.HandleFunc("/setRole", owner(mutating(setRoleHandler(bot, auth))))
+	protected.HandleFunc("/api/roles", owner(rolesHandler(auth)))
+	protected.HandleFunc("/api/history", owner(historyHandler(bot)))
+	protected.HandleFunc("/api/settings/export", owner(exportSettingsHandler(bot)))
+	protected.HandleFunc("/api/settings/snapshots", owner(snapshotsHandler(bot)))
+	protected.HandleFunc("/api/settings/import", owner(mutating(importSettingsHandler(bot))))
+	protected.HandleFunc("/api/chats", viewer(chatsHandler(bot)))
+	protected.HandleFunc("/api/messageCache", viewer(messageCacheHandler(bot)))
+	protected.HandleFunc("/api/media", viewer(mediaHandler(bot)))
+	protected.HandleFunc("/setReaction", moderator(mutating(setReactionHandler(bot))))
+	protected.HandleFunc("/api/sendMessage", moderator(mutating(sendMessageHandler(bot))))
+	protected.HandleFunc("/api/banUser", moderator(mutating(banUserHandler(bot))))
+	protected.HandleFunc("/api/deletePost", moderator(mutating(deletePostHandler(bot))))
+	protected.HandleFunc("/api/queuedPost", moderator(mutating(queuedPostHandler(bot))))
+	protected.HandleFunc("/ws", viewer(wsHandler))
+	protected.HandleFunc("/logout", viewer(mutating(logoutHandler)))
+
 	r := http.NewServeMux()
-	r.HandleFunc("/", indexHandler(bot))
-	r.HandleFunc("/setLinks", linksHandler(bot))
-	r.HandleFunc("/setChannelForward", channelForwardHandler(bot))
-	r.HandleFunc("/setAdminForward", adminForwardHandler(bot))
-	r.HandleFunc("/setAutoBan", autoBanHandler(bot))
-	r.HandleFunc("/setCaptcha", captchaHandler(bot))
-	r.HandleFunc("/setCaptchaConfig", captchaConfigHandler(bot))
-	r.HandleFunc("/setWelcomeMessage", welcomeMessageHandler(bot))
-	r.HandleFunc("/setWelcomeContent", welcomeContentHandler(bot))
-	r.HandleFunc("/setChannel", channelHandler(bot))
-	r.HandleFunc("/setGroup", groupHandler(bot))
-	r.HandleFunc("/setAdmin", adminHandler(bot))
-	r.HandleFunc("/setReplacer", replacerHandler(bot))
-	r.HandleFunc("/setBannedWords", bannedWordsHandler(bot))
-	r.HandleFunc("/api/chats", chatsHandler(bot))
-	r.HandleFunc("/api/messageCache", messageCacheHandler(bot))
-	r.HandleFunc("/api/media", mediaHandler(bot))
-	r.HandleFunc("/setReaction", setReactionHandler(bot))
-	r.HandleFunc("/ws", wsHandler)
+	r.HandleFunc("/login", loginHandler(auth, bot.Bot.Self.UserName))
+	r.Handle("/", auth.Middleware(protected))
 
 	return WebUI{
 		Server:   r,